@@ -0,0 +1,31 @@
+// Package gui holds the GUI's views of devices, so the nandpu package and
+// the peripherals built on it don't depend on fyne.
+package gui
+
+import (
+	"fyne.io/fyne/v2"
+
+	"github.com/QEStudios/NANDPUSim/nandpu"
+)
+
+// PanelProvider is implemented by devices with their own view in the GUI.
+// Panel returns the view and a function that reads the device's state, on
+// the goroutine running the machine, and returns a function that shows it,
+// on the UI goroutine.
+type PanelProvider interface {
+	Panel() (fyne.CanvasObject, func() func())
+}
+
+// Panel returns the view of a device and its read function, as
+// PanelProvider does, or false if the device has no view.
+func Panel(d nandpu.Device) (fyne.CanvasObject, func() func(), bool) {
+	switch d := d.(type) {
+	case *nandpu.Timer:
+		panel, read := timerPanel(d)
+		return panel, read, true
+	case PanelProvider:
+		panel, read := d.Panel()
+		return panel, read, true
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,30 @@
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/QEStudios/NANDPUSim/nandpu"
+)
+
+// timerPanel shows the counter and how close the watchdog is to expiring.
+func timerPanel(t *nandpu.Timer) (fyne.CanvasObject, func() func()) {
+	counter := widget.NewLabel("")
+	watchdog := widget.NewLabel("")
+	read := func() func() {
+		count, reload, prescale, ctrl := t.Counter()
+		counterText := fmt.Sprintf("Count 0x%04X / 0x%04X, prescale %d, ctrl 0x%02X", count, reload, prescale, ctrl)
+		watchdogText := "Watchdog off"
+		if running, elapsed, timeout := t.Watchdog(); running {
+			watchdogText = fmt.Sprintf("Watchdog %d / %d cycles", elapsed, timeout)
+		}
+		return func() {
+			counter.SetText(counterText)
+			watchdog.SetText(watchdogText)
+		}
+	}
+	return widget.NewCard("Timer", "", container.NewVBox(counter, watchdog)), read
+}
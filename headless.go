@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/QEStudios/NANDPUSim/nandpu"
+)
+
+// Exit codes of a headless run, above those a program can exit with.
+const (
+	EXIT_FAULT      = 120 // a CPU faulted
+	EXIT_STEP_LIMIT = 121 // the step limit was reached first
+
+	// Programs' own exit codes are passed on up to EXIT_PROGRAM_MAX.
+	EXIT_PROGRAM_MAX = 119
+)
+
+// HeadlessOptions controls a run without the GUI.
+type HeadlessOptions struct {
+	MaxSteps  uint64 // scheduler rounds; 0 runs until the program halts
+	InputPath string // keyboard input script; stdin is used when empty
+}
+
+// runHeadless runs the system until every CPU halts or faults, or the step
+// limit is reached, and returns the process exit code: EXIT_FAULT on a fault,
+// EXIT_STEP_LIMIT at the step limit, otherwise the code a program exited with
+// through semihosting.
+func runHeadless(s *nandpu.System, opts HeadlessOptions) int {
+	defer s.Close()
+
+	// Host input goes to the first CPU with a keyboard.
+	for _, m := range s.Machines {
+		if m.Keyboard == nil {
+			continue
+		}
+		if opts.InputPath != "" {
+			script, err := os.ReadFile(opts.InputPath)
+			if err != nil {
+				nandpu.Logger.Printf("Failed to read keyboard input: %v", err)
+				return 1
+			}
+			m.Keyboard.Queue(script)
+		} else {
+			m.Keyboard.Feed(os.Stdin)
+		}
+		break
+	}
+
+	var steps uint64
+	halted := false
+	for !halted && (opts.MaxSteps == 0 || steps < opts.MaxSteps) {
+		steps++
+		halted = !s.Step()
+	}
+
+	code, faulted := 0, false
+	for i, m := range s.Machines {
+		c := m.CPU
+		nandpu.Logger.Printf("%s stopped after %d cycles", s.Names[i], c.Cycles)
+		if c.Fault != nil {
+			nandpu.Logger.Printf("%s stopped on %s", s.Names[i], c.Fault)
+			faulted = true
+		}
+		// Pass on the first non-zero code a program exits with through semihosting.
+		if m.Semihost != nil && m.Semihost.Exited && code == 0 {
+			code = m.Semihost.ExitCode
+			if code > EXIT_PROGRAM_MAX {
+				nandpu.Logger.Printf("%s exited with %d, which is passed on as %d", s.Names[i], code, EXIT_PROGRAM_MAX)
+				code = EXIT_PROGRAM_MAX
+			}
+		}
+	}
+	if faulted {
+		return EXIT_FAULT
+	}
+	if !halted {
+		nandpu.Logger.Printf("Step limit of %d reached without halting", opts.MaxSteps)
+		return EXIT_STEP_LIMIT
+	}
+	return code
+}
+
+// runBenchmark runs the first CPU for n instructions with tracing off,
+// restarting the program whenever it halts, and reports the speed and the
+// heap allocations made per instruction.
+func runBenchmark(s *nandpu.System, n uint64) int {
+	defer s.Close()
+	c := s.Machines[0].CPU
+	c.Trace = false
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var done, restarts uint64
+	for done < n {
+		steps, running := c.Run(n - done)
+		done += steps
+		if !running {
+			if c.Fault != nil {
+				nandpu.Logger.Printf("Benchmark stopped on %s", c.Fault)
+				return 2
+			}
+			c.Reset()
+			restarts++
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	nandpu.Logger.Printf("%d instructions in %v (%.1f million per second), %d restarts, %.3f allocations per instruction",
+		done, elapsed, float64(done)/elapsed.Seconds()/1e6, restarts, float64(after.Mallocs-before.Mallocs)/float64(done))
+	if ic := c.ICache; ic != nil {
+		nandpu.Logger.Printf("Instruction cache: %d hits, %d misses, %d invalidations", ic.Hits, ic.Misses, ic.Invalidations)
+	}
+	return 0
+}
@@ -0,0 +1,339 @@
+package nandpu
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Access is one register or memory access made by an instruction.
+type Access struct {
+	Reg   string // register name in the ISA; empty for memory
+	Addr  uint16 // of a memory access
+	Val   uint16
+	Write bool
+	Fetch bool // a read of the instruction's own bytes
+}
+
+var registerInfo = func() map[string]RegInfo {
+	m := map[string]RegInfo{}
+	for _, r := range RegisterFile {
+		m[r.Name] = r
+	}
+	return m
+}()
+
+func (a Access) String() string {
+	op := "read"
+	switch {
+	case a.Write:
+		op = "write"
+	case a.Fetch:
+		op = "fetch"
+	}
+	if a.Reg == "" {
+		return fmt.Sprintf("%s [0x%04X]=0x%02X", op, a.Addr, a.Val)
+	}
+	if registerInfo[a.Reg].Width == 16 {
+		return fmt.Sprintf("%s %s=0x%04X", op, a.Reg, a.Val)
+	}
+	return fmt.Sprintf("%s %s=0x%02X", op, a.Reg, a.Val)
+}
+
+// InstAudit is every access one instruction made, in order.
+type InstAudit struct {
+	PC       uint16
+	Opcode   byte
+	Accesses []Access
+}
+
+func (ia *InstAudit) String() string {
+	parts := make([]string, len(ia.Accesses))
+	for i, a := range ia.Accesses {
+		parts[i] = a.String()
+	}
+	return fmt.Sprintf("AUDIT 0x%04X %s: %s", ia.PC, OpcodeNames[ia.Opcode], strings.Join(parts, ", "))
+}
+
+// Auditor records the accesses of each instruction. Register accesses come
+// from the registers' hooks.
+type Auditor struct {
+	// OnInstruction is called after each instruction. The InstAudit is
+	// reused, so copy anything kept from it.
+	OnInstruction func(*InstAudit)
+
+	cpu  *NANDPU
+	inst InstAudit
+}
+
+// EnableAudit hooks every register and starts recording accesses. The
+// instruction cache is bypassed while auditing, as cached instructions skip
+// their fetches.
+func (c *NANDPU) EnableAudit(fn func(*InstAudit)) *Auditor {
+	a := &Auditor{OnInstruction: fn, cpu: c}
+	for _, r := range RegisterFile {
+		name := r.Name
+		hooks8 := Hooks8{
+			OnRead:  func(val byte) { a.reg(name, false, uint16(val)) },
+			OnWrite: func(old, val byte) { a.reg(name, true, uint16(val)) },
+		}
+		hooks16 := Hooks16{
+			OnRead:  func(val uint16) { a.reg(name, false, val) },
+			OnWrite: func(old, val uint16) { a.reg(name, true, val) },
+		}
+		switch reg := c.Register(name).(type) {
+		case *Reg8:
+			reg.Hooks8 = hooks8
+		case *splitHi:
+			reg.Hooks8 = hooks8
+		case *splitLo:
+			reg.Hooks8 = hooks8
+		case *Reg16:
+			reg.Hooks16 = hooks16
+		case *SplitReg16:
+			reg.Hooks16 = hooks16
+		}
+	}
+	c.Audit = a
+	return a
+}
+
+func (a *Auditor) begin(pc uint16) {
+	a.inst.PC = pc
+	a.inst.Accesses = a.inst.Accesses[:0]
+}
+
+func (a *Auditor) end() {
+	if len(a.inst.Accesses) == 0 {
+		return // faulted before fetching anything
+	}
+	a.inst.Opcode = a.cpu.INST.val
+	if a.OnInstruction != nil {
+		a.OnInstruction(&a.inst)
+	}
+}
+
+func (a *Auditor) reg(name string, write bool, val uint16) {
+	a.inst.Accesses = append(a.inst.Accesses, Access{Reg: name, Val: val, Write: write})
+}
+
+func (a *Auditor) mem(addr uint16, val byte, write bool) {
+	a.inst.Accesses = append(a.inst.Accesses, Access{Addr: addr, Val: uint16(val), Write: write})
+}
+
+// fetch records a read of the instruction at PC.
+func (a *Auditor) fetch(pc uint16, val byte) {
+	a.inst.Accesses = append(a.inst.Accesses, Access{Addr: pc, Val: uint16(val), Fetch: true})
+}
+
+// Transfer is one load from the bus: what drove it and what was loaded.
+// Memory is written as [R], where R is the register holding its address.
+type Transfer struct {
+	Sources []string
+	Dest    string
+}
+
+func (t Transfer) String() string {
+	return strings.Join(t.Sources, " + ") + " -> " + t.Dest
+}
+
+// aluOps read B and C through the ALU, which then drives the bus.
+var aluOps = map[byte]bool{
+	OP_CMP: true, OP_ADD: true, OP_SUB: true, OP_INC: true, OP_DEC: true,
+	OP_NAND: true, OP_SHR: true, OP_SHL: true,
+}
+
+// Transfers groups an instruction's accesses into bus transfers. Each
+// register or memory write loads the bus with whatever was read since the
+// last transfer. A memory access takes its address from the last 16-bit
+// register read; operand bytes naming registers go to the decoder, and other
+// bytes read from memory but not loaded straight away are held in a latch.
+// A write with nothing read for it loads the latch, or the ALU's result.
+func (ia *InstAudit) Transfers() []Transfer {
+	var ts []Transfer
+	var pending []string
+	var addrs []Access // 16-bit reads that could be an address
+	load := func(dest string) {
+		if len(pending) == 0 {
+			pending = []string{"latch"}
+			if aluOps[ia.Opcode] {
+				pending = []string{"ALU"}
+			}
+		}
+		ts = append(ts, Transfer{Sources: pending, Dest: dest})
+		pending, addrs = nil, nil
+	}
+	for i, a := range ia.Accesses {
+		if a.Reg != "" && !a.Write {
+			if aluOps[ia.Opcode] && (a.Reg == "B" || a.Reg == "C") {
+				continue
+			}
+			if !slices.Contains(pending, a.Reg) {
+				pending = append(pending, a.Reg)
+			}
+			if registerInfo[a.Reg].Width == 16 {
+				addrs = append(addrs, a)
+			}
+			continue
+		}
+		if a.Reg != "" {
+			load(a.Reg)
+			continue
+		}
+
+		name := "[?]"
+		if n := len(addrs); n > 0 {
+			reg := addrs[n-1].Reg
+			name, addrs = "["+reg+"]", addrs[:n-1]
+			// Unless it was read again, the address register only drove
+			// the address.
+			if !slices.ContainsFunc(addrs, func(r Access) bool { return r.Reg == reg }) {
+				pending = slices.DeleteFunc(pending, func(s string) bool { return s == reg })
+			}
+		}
+		if a.Write {
+			load(name)
+			continue
+		}
+		pending = append(pending, name)
+		f := instFormats[ia.Opcode]
+		if offset := a.Addr - ia.PC; a.Fetch && (f.reg8|f.reg16)&(1<<offset) != 0 {
+			load("decoder")
+		} else if i+1 == len(ia.Accesses) || !ia.Accesses[i+1].Write {
+			load("latch")
+		}
+	}
+	return ts
+}
+
+// OpcodeAudit is the static report on one opcode.
+type OpcodeAudit struct {
+	Opcode    byte
+	Transfers []Transfer // for the first encoding, with every flag clear
+	Problems  []string
+}
+
+// AuditOpcodes runs every opcode with every choice of operand registers, and
+// both with every flag clear and every flag set, on a scratch CPU. It reports
+// the transfers each opcode makes and flags any that drive the bus from two
+// sources at once or read a write-only register.
+func AuditOpcodes() []OpcodeAudit {
+	c := NewNANDPU()
+	c.Trace = false
+	c.Attach(0x0000, 0x7FFF, NewRAM(0x0000, 0x8000), 0)
+	c.Attach(0x8000, 0xFFFF, NewRAM(0x8000, 0x8000), 0)
+	// Let instructions read write-only registers, to report it rather than
+	// panic.
+	for _, r := range RegisterFile {
+		c.Register(r.Name).(interface{ setAccess(AccessFlags) }).setAccess(accessRW)
+	}
+	var last InstAudit
+	c.EnableAudit(func(ia *InstAudit) {
+		last = InstAudit{PC: ia.PC, Opcode: ia.Opcode, Accesses: slices.Clone(ia.Accesses)}
+	})
+
+	var report []OpcodeAudit
+	for _, op := range slices.Sorted(maps.Keys(OpcodeNames)) {
+		oa := OpcodeAudit{Opcode: op}
+		seen := map[string]bool{}
+		problem := func(msg, example string) {
+			if !seen[msg] {
+				seen[msg] = true
+				oa.Problems = append(oa.Problems, fmt.Sprintf("%s (e.g. %s)", msg, example))
+			}
+		}
+		for _, operands := range operandChoices(instFormats[op]) {
+			for _, flags := range []bool{false, true} {
+				example := auditRun(c, op, operands, flags)
+				if oa.Transfers == nil && !flags {
+					oa.Transfers = last.Transfers()
+				}
+				for _, a := range last.Accesses {
+					if a.Reg != "" && !a.Write && !registerInfo[a.Reg].Access.CanRead {
+						problem("reads write-only "+a.Reg, example)
+					}
+				}
+				for _, t := range last.Transfers() {
+					if len(t.Sources) > 1 {
+						problem(strings.Join(t.Sources, " and ")+" drive the bus at once into "+t.Dest, example)
+					}
+				}
+			}
+		}
+		report = append(report, oa)
+	}
+	return report
+}
+
+// operandChoices lists the bytes of every encoding of an instruction format,
+// with the operands that aren't registers pointing into RAM.
+func operandChoices(f instFormat) [][]byte {
+	choices := [][]byte{{}}
+	for i := uint16(1); i < f.length; i++ {
+		values := []byte{0x90}
+		if f.reg8&(1<<i) != 0 {
+			values = slices.Sorted(maps.Keys(Reg8Names))
+		} else if f.reg16&(1<<i) != 0 {
+			values = slices.Sorted(maps.Keys(Reg16Names))
+		}
+		var next [][]byte
+		for _, prefix := range choices {
+			for _, v := range values {
+				next = append(next, append(slices.Clone(prefix), v))
+			}
+		}
+		choices = next
+	}
+	return choices
+}
+
+// auditRun executes one encoding of op from a known state and returns how
+// it is written, e.g. "MOV8 J1, A".
+func auditRun(c *NANDPU, op byte, operands []byte, flags bool) string {
+	c.Reset()
+	c.PC.val, c.SP.val = 0x1000, 0xF000
+	c.RegA.val, c.RegB.val, c.RegC.val, c.RegD.val = 0x0A, 0x0B, 0x0C, 0x0D
+	c.RegM.val, c.RegXY.val, c.RegJ.val = 0x9000, 0xA000, 0x2000
+	c.Zero, c.Carry, c.Sign, c.LessThan = flags, flags, flags, flags
+	c.Mem.Write(0x1000, op)
+	f := instFormats[op]
+	var names []string
+	for i, b := range operands {
+		offset := uint16(i + 1)
+		c.Mem.Write(0x1000+offset, b)
+		if f.reg8&(1<<offset) != 0 {
+			names = append(names, regName(8, b))
+		} else if f.reg16&(1<<offset) != 0 {
+			names = append(names, regName(16, b))
+		}
+	}
+	c.Step()
+	if len(names) == 0 {
+		return OpcodeNames[op]
+	}
+	return OpcodeNames[op] + " " + strings.Join(names, ", ")
+}
+
+func regName(width int, index byte) string {
+	for _, r := range RegisterFile {
+		if r.Operand && r.Width == width && r.Index == index {
+			return r.Name
+		}
+	}
+	return "?"
+}
+
+// WriteAuditReport writes the static report on every opcode to w.
+func WriteAuditReport(w io.Writer) {
+	for _, oa := range AuditOpcodes() {
+		fmt.Fprintf(w, "%s (0x%02X)\n", OpcodeNames[oa.Opcode], oa.Opcode)
+		for _, t := range oa.Transfers {
+			fmt.Fprintf(w, "  %s\n", t)
+		}
+		for _, p := range oa.Problems {
+			fmt.Fprintf(w, "  !! %s\n", p)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package nandpu
+
+import "fmt"
+
+// Bank-switched windows and the bank select registers that control them.
+const (
+	ROM_WINDOW_START uint16 = 0x4000
+	ROM_WINDOW_END   uint16 = 0x7FFF
+	RAM_WINDOW_START uint16 = 0x8000
+	RAM_WINDOW_END   uint16 = 0xBFFF
+	BANK_SIZE               = 0x4000
+
+	BANK_SELECT_ROM  uint16 = 0x0 // bank shown in the ROM window
+	BANK_SELECT_RAM  uint16 = 0x1 // bank shown in the RAM window
+	BANK_SELECT_SIZE uint16 = 0x2
+)
+
+// BankedMemory is a window in the address space backed by one of several
+// equally sized banks.
+type BankedMemory struct {
+	banks    [][]byte
+	base     uint16
+	current  int
+	initial  int
+	writable bool
+	written  [][]byte // bitmaps by bank of bytes written since reset, when tracked
+}
+
+// NewBankedROM splits a ROM image into banks of the given size, showing
+// initial in the window after reset.
+func NewBankedROM(base uint16, size int, image []byte, initial int) *BankedMemory {
+	count := max((len(image)+size-1)/size, initial+1)
+	b := &BankedMemory{base: base, current: initial, initial: initial}
+	for i := range count {
+		bank := make([]byte, size)
+		if i*size < len(image) {
+			copy(bank, image[i*size:])
+		}
+		b.banks = append(b.banks, bank)
+	}
+	return b
+}
+
+// NewBankedRAM creates count zeroed RAM banks of the given size.
+func NewBankedRAM(base uint16, size, count int) *BankedMemory {
+	b := &BankedMemory{base: base, writable: true}
+	for range count {
+		b.banks = append(b.banks, make([]byte, size))
+	}
+	return b
+}
+
+func (b *BankedMemory) Read(addr uint16) byte {
+	return b.banks[b.current][addr-b.base]
+}
+
+func (b *BankedMemory) Write(addr uint16, val byte) {
+	if b.writable {
+		b.Poke(addr, val)
+	}
+}
+
+func (b *BankedMemory) Peek(addr uint16) byte { return b.banks[b.current][addr-b.base] }
+func (b *BankedMemory) Poke(addr uint16, val byte) {
+	off := addr - b.base
+	b.banks[b.current][off] = val
+	if b.written != nil {
+		b.written[b.current][off/8] |= 1 << (off % 8)
+	}
+}
+
+// TrackWrites starts recording which bytes of each bank have been written
+// since reset. Only RAM banks are tracked.
+func (b *BankedMemory) TrackWrites() {
+	if !b.writable || b.written != nil {
+		return
+	}
+	for range b.banks {
+		b.written = append(b.written, make([]byte, (len(b.banks[0])+7)/8))
+	}
+}
+
+// Written reports whether addr has been written since reset in the bank
+// shown. Untracked banks count as written.
+func (b *BankedMemory) Written(addr uint16) bool {
+	off := addr - b.base
+	return b.written == nil || b.written[b.current][off/8]&(1<<(off%8)) != 0
+}
+
+// Select switches the window to a bank, wrapping around the number of banks
+// as unconnected high select bits would.
+func (b *BankedMemory) Select(bank byte) {
+	b.current = int(bank) % len(b.banks)
+}
+
+func (b *BankedMemory) Contains(addr uint16) bool {
+	return addr >= b.base && int(addr-b.base) < len(b.banks[0])
+}
+
+func (b *BankedMemory) Bank() int  { return b.current }
+func (b *BankedMemory) Banks() int { return len(b.banks) }
+
+func (b *BankedMemory) Reset() {
+	b.current = b.initial
+	for _, w := range b.written {
+		clear(w)
+	}
+}
+
+// BankSelect holds one bank select register per window.
+type BankSelect struct {
+	base    uint16
+	windows []*BankedMemory
+}
+
+func NewBankSelect(base uint16, windows ...*BankedMemory) *BankSelect {
+	return &BankSelect{base: base, windows: windows}
+}
+
+func (s *BankSelect) Read(addr uint16) byte {
+	if w := s.window(addr); w != nil {
+		return byte(w.current)
+	}
+	return 0x00
+}
+
+func (s *BankSelect) Write(addr uint16, val byte) {
+	if w := s.window(addr); w != nil {
+		w.Select(val)
+	}
+}
+
+func (s *BankSelect) Peek(addr uint16) byte { return s.Read(addr) }
+
+// Poke does nothing, as the registers only hold the banks shown and changing
+// them would remap memory under the debugger.
+func (s *BankSelect) Poke(addr uint16, val byte) {}
+
+func (s *BankSelect) window(addr uint16) *BankedMemory {
+	i := int(addr - s.base)
+	if i >= len(s.windows) {
+		return nil
+	}
+	return s.windows[i]
+}
+
+// BankedAddr identifies a location in a banked address space. Bank is -1 for
+// addresses outside any bank-switched window.
+type BankedAddr struct {
+	Bank int
+	Addr uint16
+}
+
+func (a BankedAddr) String() string {
+	if a.Bank < 0 {
+		return fmt.Sprintf("0x%04X", a.Addr)
+	}
+	return fmt.Sprintf("%02X:0x%04X", a.Bank, a.Addr)
+}
@@ -0,0 +1,13 @@
+package nandpu
+
+import "testing"
+
+func TestBankSelectPokeDoesNotRemap(t *testing.T) {
+	m := newMachine(t, nil, MachineOptions{RAMBanks: 4})
+	mem := &m.CPU.Mem
+	mem.Write(BANK_BASE+BANK_SELECT_RAM, 2)
+	mem.Poke(BANK_BASE+BANK_SELECT_RAM, 3)
+	if m.RAMBank.Bank() != 2 || mem.Peek(BANK_BASE+BANK_SELECT_RAM) != 2 {
+		t.Errorf("bank %d after a debug poke, want 2", m.RAMBank.Bank())
+	}
+}
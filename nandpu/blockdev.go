@@ -0,0 +1,254 @@
+package nandpu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Block device register offsets from the device's base address.
+const (
+	BLK_CMD       uint16 = 0x0 // write BLK_CMD_READ or BLK_CMD_WRITE to start a transfer
+	BLK_STATUS    uint16 = 0x1 // bit 0 busy, bit 1 error, bit 2 read-only, bit 3 done; write 1 to clear error/done
+	BLK_SECTOR_0  uint16 = 0x2 // sector address, little endian
+	BLK_SECTOR_1  uint16 = 0x3
+	BLK_SECTOR_2  uint16 = 0x4
+	BLK_SECTOR_3  uint16 = 0x5
+	BLK_DATA      uint16 = 0x6 // data window into the sector buffer; auto-increments the index
+	BLK_INDEX_LO  uint16 = 0x7 // position of the data window in the sector buffer
+	BLK_INDEX_HI  uint16 = 0x8
+	BLK_CTRL      uint16 = 0x9 // bit 0 interrupt enable
+	BLK_SIZE      uint16 = 0x10
+	SECTOR_SIZE          = 512
+	BLK_CMD_READ  byte   = 0x01
+	BLK_CMD_WRITE byte   = 0x02
+
+	// Cycles a sector transfer keeps the device busy for.
+	blkLatency = 1024
+)
+
+const (
+	blkStatusBusy byte = 1 << iota
+	blkStatusError
+	blkStatusReadOnly
+	blkStatusDone
+)
+
+// BlockDevice reads and writes 512-byte sectors of a host disk image. The CPU
+// fills or drains the sector buffer through the data window, then issues a
+// command; the transfer completes after a fixed number of cycles.
+type BlockDevice struct {
+	base     uint16
+	file     *os.File
+	readOnly bool
+
+	buf     [SECTOR_SIZE]byte
+	index   uint16
+	sector  uint32
+	status  byte
+	ctrl    byte
+	pending byte
+	busy    uint64
+
+	OnInterrupt func()
+}
+
+func init() {
+	RegisterDevice("disk", BLK_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.Disk != nil {
+			return nil, fmt.Errorf("only one disk is supported")
+		}
+		disk, err := NewBlockDevice(r.Base, cfg.imagePath(r), r.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		m.Disk = disk
+		return disk, nil
+	})
+}
+
+// NewBlockDevice opens the disk image at path, which must already exist.
+func NewBlockDevice(base uint16, path string, readOnly bool) (*BlockDevice, error) {
+	flags := os.O_RDWR
+	if readOnly {
+		flags = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockDevice{base: base, file: file, readOnly: readOnly}, nil
+}
+
+func (b *BlockDevice) Read(addr uint16) byte {
+	val := b.Peek(addr)
+	if addr-b.base == BLK_DATA {
+		b.index = (b.index + 1) % SECTOR_SIZE
+	}
+	return val
+}
+
+// Peek shows the byte under the data window without advancing it.
+func (b *BlockDevice) Peek(addr uint16) byte {
+	switch addr - b.base {
+	case BLK_STATUS:
+		status := b.status
+		if b.readOnly {
+			status |= blkStatusReadOnly
+		}
+		return status
+	case BLK_SECTOR_0:
+		return byte(b.sector)
+	case BLK_SECTOR_1:
+		return byte(b.sector >> 8)
+	case BLK_SECTOR_2:
+		return byte(b.sector >> 16)
+	case BLK_SECTOR_3:
+		return byte(b.sector >> 24)
+	case BLK_DATA:
+		return b.buf[b.index]
+	case BLK_INDEX_LO:
+		return byte(b.index)
+	case BLK_INDEX_HI:
+		return byte(b.index >> 8)
+	case BLK_CTRL:
+		return b.ctrl
+	}
+	return 0x00
+}
+
+func (b *BlockDevice) Write(addr uint16, val byte) {
+	switch addr - b.base {
+	case BLK_CMD:
+		if b.status&blkStatusBusy != 0 {
+			return
+		}
+		b.status &^= blkStatusError | blkStatusDone
+		if val != BLK_CMD_READ && val != BLK_CMD_WRITE {
+			b.status |= blkStatusError
+			return
+		}
+		b.pending = val
+		b.busy = blkLatency
+		b.status |= blkStatusBusy
+	case BLK_STATUS:
+		b.status &^= val & (blkStatusError | blkStatusDone)
+	case BLK_SECTOR_0:
+		b.sector = (b.sector &^ 0x000000FF) | uint32(val)
+	case BLK_SECTOR_1:
+		b.sector = (b.sector &^ 0x0000FF00) | (uint32(val) << 8)
+	case BLK_SECTOR_2:
+		b.sector = (b.sector &^ 0x00FF0000) | (uint32(val) << 16)
+	case BLK_SECTOR_3:
+		b.sector = (b.sector &^ 0xFF000000) | (uint32(val) << 24)
+	case BLK_DATA:
+		b.buf[b.index] = val
+		b.index = (b.index + 1) % SECTOR_SIZE
+	case BLK_INDEX_LO:
+		b.index = ((b.index & 0xFF00) | uint16(val)) % SECTOR_SIZE
+	case BLK_INDEX_HI:
+		b.index = ((b.index & 0x00FF) | (uint16(val) << 8)) % SECTOR_SIZE
+	case BLK_CTRL:
+		b.ctrl = val
+	}
+}
+
+// Poke sets register contents without starting a transfer or moving the data
+// window.
+func (b *BlockDevice) Poke(addr uint16, val byte) {
+	switch addr - b.base {
+	case BLK_CMD:
+	case BLK_STATUS:
+		b.status = val &^ blkStatusReadOnly
+	case BLK_DATA:
+		b.buf[b.index] = val
+	default:
+		b.Write(addr, val)
+	}
+}
+
+func (b *BlockDevice) Tick(cycles uint64) {
+	if b.status&blkStatusBusy == 0 {
+		return
+	}
+	if b.busy > cycles {
+		b.busy -= cycles
+		return
+	}
+
+	if err := b.transfer(); err != nil {
+		Logger.Printf("DISK %s sector %d failed: %v", blkCmdName(b.pending), b.sector, err)
+		b.status |= blkStatusError
+	} else {
+		Logger.Printf("DISK %s sector %d", blkCmdName(b.pending), b.sector)
+	}
+	b.status = (b.status &^ blkStatusBusy) | blkStatusDone
+	b.index = 0
+	b.pending = 0
+	if b.ctrl&0x01 != 0 && b.OnInterrupt != nil {
+		b.OnInterrupt()
+	}
+}
+
+func (b *BlockDevice) transfer() error {
+	offset := int64(b.sector) * SECTOR_SIZE
+	if b.pending == BLK_CMD_WRITE {
+		if b.readOnly {
+			return errors.New("disk image is read-only")
+		}
+		_, err := b.file.WriteAt(b.buf[:], offset)
+		return err
+	}
+
+	// Sectors past the end of the image read back as zeroes.
+	n, err := b.file.ReadAt(b.buf[:], offset)
+	clear(b.buf[n:])
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func blkCmdName(cmd byte) string {
+	if cmd == BLK_CMD_WRITE {
+		return "write"
+	}
+	return "read"
+}
+
+func (b *BlockDevice) Reset() {
+	b.index = 0
+	b.sector = 0
+	b.status = 0
+	b.ctrl = 0
+	b.pending = 0
+	b.busy = 0
+}
+
+func (b *BlockDevice) Close() error { return b.file.Close() }
+
+func (b *BlockDevice) SetInterruptHandler(fn func()) { b.OnInterrupt = fn }
+
+// blockDeviceState holds the controller state; the image itself is not saved.
+type blockDeviceState struct {
+	Buf                   []byte
+	Index                 uint16
+	Sector                uint32
+	Status, Ctrl, Pending byte
+	Busy                  uint64
+}
+
+func (b *BlockDevice) Snapshot() ([]byte, error) {
+	return encodeState(blockDeviceState{b.buf[:], b.index, b.sector, b.status, b.ctrl, b.pending, b.busy})
+}
+
+func (b *BlockDevice) Restore(data []byte) error {
+	var s blockDeviceState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	copy(b.buf[:], s.Buf)
+	b.index, b.sector, b.status, b.ctrl, b.pending, b.busy = s.Index, s.Sector, s.Status, s.Ctrl, s.Pending, s.Busy
+	return nil
+}
@@ -0,0 +1,71 @@
+package nandpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newDisk(t *testing.T, image []byte, readOnly bool) (*BlockDevice, string) {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlockDevice(DISK_BASE, path, readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b, path
+}
+
+// command starts a transfer of sector and waits for it to finish.
+func command(t *testing.T, b *BlockDevice, cmd byte, sector byte) byte {
+	b.Write(DISK_BASE+BLK_SECTOR_0, sector)
+	b.Write(DISK_BASE+BLK_CMD, cmd)
+	b.Tick(blkLatency - 1)
+	if b.Read(DISK_BASE+BLK_STATUS)&blkStatusBusy == 0 {
+		t.Fatal("transfer finished early")
+	}
+	b.Tick(1)
+	return b.Read(DISK_BASE + BLK_STATUS)
+}
+
+func TestBlockDeviceReadWrite(t *testing.T) {
+	image := make([]byte, 2*SECTOR_SIZE)
+	image[SECTOR_SIZE] = 0x42
+	b, path := newDisk(t, image, false)
+
+	if status := command(t, b, BLK_CMD_READ, 1); status != blkStatusDone {
+		t.Fatalf("status 0x%02X after a read, want 0x%02X", status, blkStatusDone)
+	}
+	if val := b.Read(DISK_BASE + BLK_DATA); val != 0x42 {
+		t.Errorf("read 0x%02X from sector 1, want 0x42", val)
+	}
+
+	b.Write(DISK_BASE+BLK_INDEX_LO, 0)
+	b.Write(DISK_BASE+BLK_DATA, 0xAA)
+	b.Write(DISK_BASE+BLK_DATA, 0x55)
+	command(t, b, BLK_CMD_WRITE, 3) // past the end of the image
+	data, _ := os.ReadFile(path)
+	if len(data) != 4*SECTOR_SIZE || data[3*SECTOR_SIZE] != 0xAA || data[3*SECTOR_SIZE+1] != 0x55 {
+		t.Errorf("sector 3 not written to the image")
+	}
+
+	command(t, b, BLK_CMD_READ, 2)
+	if val := b.Read(DISK_BASE + BLK_DATA); val != 0 {
+		t.Errorf("read 0x%02X from a sector never written, want 0x00", val)
+	}
+}
+
+func TestBlockDeviceReadOnly(t *testing.T) {
+	b, _ := newDisk(t, make([]byte, SECTOR_SIZE), true)
+	status := command(t, b, BLK_CMD_WRITE, 0)
+	if want := blkStatusError | blkStatusReadOnly | blkStatusDone; status != want {
+		t.Errorf("status 0x%02X after writing a read-only disk, want 0x%02X", status, want)
+	}
+	b.Write(DISK_BASE+BLK_STATUS, blkStatusError|blkStatusDone)
+	if status := b.Read(DISK_BASE + BLK_STATUS); status != blkStatusReadOnly {
+		t.Errorf("status 0x%02X after clearing error and done, want 0x%02X", status, blkStatusReadOnly)
+	}
+}
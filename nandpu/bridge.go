@@ -0,0 +1,313 @@
+package nandpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bridge forwards accesses to an address window to a device modelled in
+// another process, connected over a local socket or the stdin/stdout of a
+// process the bridge starts.
+//
+// The protocol is line-based ASCII. The simulator sends one request per line
+// and waits for its reply. Offsets are four hex digits relative to the start
+// of the window, values two hex digits and cycle counts decimal:
+//
+//	H <size>            hello, sent once on connect    -> K
+//	R <offset>          CPU read                       -> D <value>
+//	W <offset> <value>  CPU write                      -> K
+//	P <offset>          debugger read, no side effects -> D <value>
+//	O <offset> <value>  debugger write, no side effects -> K
+//	T <cycles>          cycles elapsed since the last T -> K
+//	X                   reset                          -> K
+//
+// Before any reply the device may send "I" lines to raise an interrupt, or
+// reply "E <message>" to report an error, which faults the CPU unless the
+// request came from the debugger. Cycles are
+// batched and sent at least every tick interval and before every access, so
+// the device always sees the current time when it is accessed. For example:
+//
+//	-> H 0010
+//	<- K
+//	-> T 120
+//	<- K
+//	-> W 0000 41
+//	<- I
+//	<- K
+type Bridge struct {
+	mu   sync.Mutex
+	base uint16
+	cpu  *NANDPU
+
+	conn io.ReadWriteCloser
+	cmd  *exec.Cmd
+	r    *bufio.Reader
+	w    *bufio.Writer
+	err  error
+	seen []byte // last value read or written at each offset
+
+	TickInterval uint64
+	pending      uint64
+
+	OnInterrupt func()
+}
+
+const DEFAULT_BRIDGE_TICK_INTERVAL = 1000
+
+func init() {
+	RegisterDevice("bridge", 0x10, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		connect, _ := r.Options["connect"].(string)
+		command, _ := r.Options["command"].(string)
+		interval, _ := r.Options["tick_interval"].(int64)
+		if interval <= 0 {
+			interval = DEFAULT_BRIDGE_TICK_INTERVAL
+		}
+
+		var b *Bridge
+		var err error
+		switch {
+		case connect != "" && command == "":
+			b, err = DialBridge(r.Base, m.CPU, connect)
+		case command != "" && connect == "":
+			b, err = StartBridge(r.Base, m.CPU, command, cfg.dir)
+		default:
+			return nil, fmt.Errorf("bridge needs either a connect or a command option")
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.TickInterval = uint64(interval)
+
+		size := r.Size
+		if size == 0 {
+			size = 0x10
+		}
+		b.seen = make([]byte, size)
+		if _, err := b.request("H %04X", size); err != nil {
+			b.Close()
+			return nil, err
+		}
+		return b, nil
+	})
+}
+
+// DialBridge connects to a device listening on "unix:<path>" or
+// "tcp:<host>:<port>".
+func DialBridge(base uint16, cpu *NANDPU, addr string) (*Bridge, error) {
+	network, address, ok := strings.Cut(addr, ":")
+	if !ok {
+		return nil, fmt.Errorf("bridge address %q should be unix:<path> or tcp:<host>:<port>", addr)
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newBridge(base, cpu, conn, conn), nil
+}
+
+// StartBridge runs a device model and talks to it over its stdin and stdout.
+// Its stderr is passed through.
+func StartBridge(base uint16, cpu *NANDPU, command, dir string) (*Bridge, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("bridge command is empty")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	b := newBridge(base, cpu, stdout, stdin)
+	b.cmd = cmd
+	return b, nil
+}
+
+func newBridge(base uint16, cpu *NANDPU, r io.Reader, w io.WriteCloser) *Bridge {
+	return &Bridge{base: base, cpu: cpu, conn: struct {
+		io.Reader
+		io.WriteCloser
+	}{r, w}, r: bufio.NewReader(r), w: bufio.NewWriter(w), TickInterval: DEFAULT_BRIDGE_TICK_INTERVAL}
+}
+
+// request sends one line for the CPU and returns the reply. The first
+// failure faults the CPU and disables the bridge.
+func (b *Bridge) request(format string, args ...any) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	reply, err := b.exchange(format, args...)
+	if err != nil {
+		b.fail(err)
+	}
+	return reply, err
+}
+
+// exchange sends one line and returns the reply, handling any interrupts
+// sent ahead of it.
+func (b *Bridge) exchange(format string, args ...any) (string, error) {
+	fmt.Fprintf(b.w, format+"\n", args...)
+	err := b.w.Flush()
+	for err == nil {
+		var line string
+		line, err = b.r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "I":
+			if b.OnInterrupt != nil {
+				b.OnInterrupt()
+			}
+		case strings.HasPrefix(line, "E"):
+			err = errors.New(strings.TrimSpace(line[1:]))
+		default:
+			return line, nil
+		}
+	}
+	return "", err
+}
+
+func (b *Bridge) fail(err error) {
+	b.err = err
+	b.cpu.RaiseFault(fmt.Sprintf("bridge at 0x%04X: %v", b.base, err))
+}
+
+func (b *Bridge) flushTicks() {
+	if b.pending == 0 {
+		return
+	}
+	b.request("T %d", b.pending)
+	b.pending = 0
+}
+
+// data parses a "D <value>" reply.
+func data(reply string) (byte, error) {
+	val, ok := strings.CutPrefix(reply, "D ")
+	n, err := strconv.ParseUint(val, 16, 8)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("unexpected reply %q", reply)
+	}
+	return byte(n), nil
+}
+
+func (b *Bridge) see(addr uint16, val byte) {
+	if off := int(addr - b.base); off < len(b.seen) {
+		b.seen[off] = val
+	}
+}
+
+func (b *Bridge) Read(addr uint16) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushTicks()
+	reply, err := b.request("R %04X", addr-b.base)
+	if err != nil {
+		return 0xFF
+	}
+	val, err := data(reply)
+	if err != nil {
+		b.fail(err)
+		return 0xFF
+	}
+	b.see(addr, val)
+	return val
+}
+
+// Peek asks the device for a value without side effects. Failures are
+// left for the CPU's next access to find, and read as 0xFF.
+func (b *Bridge) Peek(addr uint16) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0xFF
+	}
+	reply, err := b.exchange("P %04X", addr-b.base)
+	if err != nil {
+		return 0xFF
+	}
+	val, err := data(reply)
+	if err != nil {
+		return 0xFF
+	}
+	b.see(addr, val)
+	return val
+}
+
+// cached returns the value last read or written at addr without asking the
+// device, for views that refresh too often to make a request per byte.
+func (b *Bridge) cached(addr uint16) byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if off := int(addr - b.base); off < len(b.seen) {
+		return b.seen[off]
+	}
+	return 0xFF
+}
+
+func (b *Bridge) Write(addr uint16, val byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushTicks()
+	if _, err := b.request("W %04X %02X", addr-b.base, val); err == nil {
+		b.see(addr, val)
+	}
+}
+
+func (b *Bridge) Poke(addr uint16, val byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return
+	}
+	if _, err := b.exchange("O %04X %02X", addr-b.base, val); err == nil {
+		b.see(addr, val)
+	}
+}
+
+func (b *Bridge) Tick(cycles uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending += cycles
+	if b.pending >= b.TickInterval {
+		b.flushTicks()
+	}
+}
+
+func (b *Bridge) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = 0
+	b.request("X")
+}
+
+func (b *Bridge) SetInterruptHandler(fn func()) { b.OnInterrupt = fn }
+
+// Close hangs up, which tells the device model to exit, and waits for it if
+// the bridge started it.
+func (b *Bridge) Close() error {
+	err := b.conn.Close()
+	if b.cmd != nil {
+		if werr := b.cmd.Wait(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package nandpu
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+// fakeDevice answers bridge requests with the replies given, in order.
+func fakeDevice(t *testing.T, c *NANDPU, replies ...string) *Bridge {
+	reqR, reqW := io.Pipe()
+	repR, repW := io.Pipe()
+	go func() {
+		in := bufio.NewReader(reqR)
+		for _, reply := range replies {
+			if _, err := in.ReadString('\n'); err != nil {
+				return
+			}
+			io.WriteString(repW, reply+"\n")
+		}
+		repW.Close()
+		io.Copy(io.Discard, in)
+	}()
+	b := newBridge(0x7000, c, repR, reqW)
+	b.seen = make([]byte, 0x10)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestBridgePeekDoesNotFault(t *testing.T) {
+	c := newMachine(t, nil, MachineOptions{}).CPU
+	b := fakeDevice(t, c, "D 42", "E no such register", "D 17")
+	if val := b.Read(0x7001); val != 0x42 {
+		t.Errorf("Read = %02X, want 42", val)
+	}
+	if val := b.Peek(0x7002); val != 0xFF || c.Fault != nil {
+		t.Errorf("failed Peek = %02X with fault %v, want FF without one", val, c.Fault)
+	}
+	if val := b.Peek(0x7003); val != 0x17 {
+		t.Errorf("Peek = %02X, want 17", val)
+	}
+	if b.cached(0x7001) != 0x42 || b.cached(0x7003) != 0x17 {
+		t.Errorf("cached values %02X and %02X, want 42 and 17", b.cached(0x7001), b.cached(0x7003))
+	}
+
+	b.Read(0x7000) // the device has hung up
+	if c.Fault == nil {
+		t.Error("Read after the device hung up didn't fault")
+	}
+}
+
+func TestStartBridgeEmptyCommand(t *testing.T) {
+	if _, err := StartBridge(0x7000, nil, " ", ""); err == nil {
+		t.Error("StartBridge with an empty command succeeded")
+	}
+}
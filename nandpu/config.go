@@ -0,0 +1,212 @@
+package nandpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+const DEFAULT_CLOCK_HZ = 1000000
+
+// Default priorities of each region type. Banked windows sit over plain
+// memory, battery-backed RAM over both, and device registers over everything;
+// registered devices default to 3.
+var defaultPriorities = map[string]int{
+	"rom":         0,
+	"ram":         0,
+	"eeprom":      0,
+	"mirror":      0,
+	"banked_rom":  1,
+	"banked_ram":  1,
+	"nvram":       2,
+	"bank_select": 3,
+}
+
+// MachineConfig describes a board: its clock, reset state and memory map.
+// Regions may only overlap where they have different priorities.
+type MachineConfig struct {
+	Name      string         `toml:"name"`
+	ClockHz   uint64         `toml:"clock_hz"`
+	ResetPC   uint16         `toml:"reset_pc"`
+	InitialSP uint16         `toml:"initial_sp"`
+	OpenBus   byte           `toml:"open_bus"` // value read from unmapped addresses
+	Unmapped  string         `toml:"unmapped"` // ignore, warn or fault on unmapped accesses
+	Regions   []RegionConfig `toml:"region"`
+
+	UninitRead string `toml:"uninit_read"` // ignore, warn or fault on reads of ram regions not written since reset
+	RAMFill    string `toml:"ram_fill"`    // zero or random contents of ram regions at power-on and reset
+	RAMSeed    uint64 `toml:"ram_seed"`    // seed for random contents
+
+	Stack *StackConfig `toml:"stack"`
+
+	Protection string          `toml:"protection"` // ignore, warn (the default) or fault on accesses the permissions don't allow
+	Protect    []ProtectConfig `toml:"protect"`    // permissions over the regions' own
+
+	// DefaultImage is loaded into ROM regions that don't name their own image,
+	// normally the program given on the command line.
+	DefaultImage string `toml:"-"`
+
+	dir string
+}
+
+// RegionConfig describes one memory region or device in the memory map.
+type RegionConfig struct {
+	Type  string `toml:"type"` // rom, ram, nvram, eeprom, banked_rom, banked_ram, bank_select, mirror or a registered device type
+	Name  string `toml:"name"`
+	Base  uint16 `toml:"base"`
+	Size  int    `toml:"size"`  // devices default to the size of their register block
+	Image string `toml:"image"` // relative to the config file
+
+	// Priority overrides the type's default priority.
+	Priority *int `toml:"priority"`
+
+	Save        bool     `toml:"save"`         // eeprom: write changes back to the image
+	ReadOnly    bool     `toml:"read_only"`    // disk: refuse sector writes
+	Banks       int      `toml:"banks"`        // banked_ram: number of banks
+	InitialBank int      `toml:"initial_bank"` // banked_rom: bank selected on reset
+	Windows     []string `toml:"windows"`      // bank_select: banked regions, one select register each; "" leaves a register unconnected
+	Target      string   `toml:"target"`       // mirror: name of the mirrored region
+	Mask        *uint16  `toml:"mask"`         // mirror: offset mask, defaults to the target's size - 1
+	Baud        int      `toml:"baud"`         // uart: line speed, default 9600
+	Latency     uint64   `toml:"latency"`      // math: cycles per operation, default depends on the operation
+	Dir         string   `toml:"dir"`          // semihost: sandbox for file access, relative to the config file
+	Perms       string   `toml:"perms"`        // read, write and execute permissions like "r-x"; the default depends on the type
+
+	// Options holds settings for device types registered outside this file.
+	Options map[string]any `toml:"options"`
+}
+
+func (r RegionConfig) priority() int {
+	if r.Priority != nil {
+		return *r.Priority
+	}
+	return defaultPriorities[r.Type]
+}
+
+// LoadMachineConfig reads a board description from a TOML file.
+func LoadMachineConfig(path string) (*MachineConfig, error) {
+	cfg := &MachineConfig{}
+	meta, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %q in %s", undecoded[0].String(), path)
+	}
+
+	if !meta.IsDefined("initial_sp") {
+		cfg.InitialSP = 0xFFFF
+	}
+	if !meta.IsDefined("open_bus") {
+		cfg.OpenBus = 0xFF
+	}
+	if cfg.ClockHz == 0 {
+		cfg.ClockHz = DEFAULT_CLOCK_HZ
+	}
+	cfg.dir = filepath.Dir(path)
+	return cfg, nil
+}
+
+// NeedsROM reports whether any region relies on the default image.
+func (cfg *MachineConfig) NeedsROM() bool {
+	for _, r := range cfg.Regions {
+		switch r.Type {
+		case "rom", "eeprom", "banked_rom":
+			if r.Image == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imagePath resolves a region's image relative to the config file, falling
+// back to the default image.
+func (cfg *MachineConfig) imagePath(r RegionConfig) string {
+	if r.Image == "" {
+		return cfg.DefaultImage
+	}
+	if filepath.IsAbs(r.Image) {
+		return r.Image
+	}
+	return filepath.Join(cfg.dir, r.Image)
+}
+
+func (cfg *MachineConfig) loadImage(r RegionConfig) (string, []byte, error) {
+	path := cfg.imagePath(r)
+	if path == "" {
+		return "", nil, fmt.Errorf("no image given for %s region at 0x%04X", r.Type, r.Base)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	Logger.Printf("Loaded %d bytes from %s\n", len(data), path)
+	return path, data, nil
+}
+
+// Config returns the board described by the command line options: the
+// standard 32K ROM and 32K RAM with the selected peripherals on top.
+func (o MachineOptions) Config() *MachineConfig {
+	cfg := &MachineConfig{ClockHz: DEFAULT_CLOCK_HZ, InitialSP: 0xFFFF, OpenBus: o.OpenBus, Unmapped: o.Unmapped,
+		UninitRead: o.UninitRead, RAMFill: o.RAMFill, RAMSeed: o.RAMSeed}
+	if o.Stack != (StackConfig{}) {
+		cfg.Stack = &o.Stack
+	}
+	cfg.Protection = o.Protection
+	cfg.Protect = o.Protect
+
+	rom := RegionConfig{Type: "rom", Name: "rom", Base: 0x0000, Size: 0x8000} // 32K ROM (AT28C256)
+	if o.EEPROM {
+		rom.Type = "eeprom"
+		rom.Save = o.EEPROMSave
+	}
+	cfg.Regions = append(cfg.Regions,
+		rom,
+		RegionConfig{Type: "ram", Name: "ram", Base: 0x8000, Size: 0x8000}, // 32K RAM (CY62256N)
+	)
+
+	// The select registers are at fixed offsets, whichever windows exist.
+	windows := make([]string, BANK_SELECT_SIZE)
+	if o.ROMBanks {
+		// Bank 0 is always visible below the window, so the window starts on
+		// bank 1 and images up to 32K keep their flat layout.
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "banked_rom", Name: "rom_window", Base: ROM_WINDOW_START, Size: BANK_SIZE, InitialBank: 1})
+		windows[BANK_SELECT_ROM] = "rom_window"
+	}
+	if o.RAMBanks > 0 {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "banked_ram", Name: "ram_window", Base: RAM_WINDOW_START, Size: BANK_SIZE, Banks: o.RAMBanks})
+		windows[BANK_SELECT_RAM] = "ram_window"
+	}
+	if o.ROMBanks || o.RAMBanks > 0 {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "bank_select", Base: BANK_BASE, Windows: windows})
+	}
+
+	for _, nv := range o.NVRAM {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "nvram", Base: nv.Start, Size: int(nv.End-nv.Start) + 1, Image: nv.Path})
+	}
+	if o.Timer {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "timer", Base: TIMER_BASE})
+	}
+	if o.Keyboard {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "keyboard", Base: KEYBOARD_BASE})
+	}
+	if o.Disk != "" {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "disk", Base: DISK_BASE, Image: o.Disk, ReadOnly: o.DiskReadOnly})
+	}
+	if o.UART {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "uart", Name: "uart", Base: UART_BASE})
+	}
+	if o.DMA {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "dma", Base: DMA_BASE})
+	}
+	if o.Math {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "math", Base: MATH_BASE, Latency: o.MathLatency})
+	}
+	if o.Semihost {
+		cfg.Regions = append(cfg.Regions, RegionConfig{Type: "semihost", Base: SEMIHOST_BASE, Dir: o.SemihostDir})
+	}
+	return cfg
+}
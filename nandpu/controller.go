@@ -0,0 +1,355 @@
+package nandpu
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RUN_CHUNK is the most steps run between checks for commands.
+	RUN_CHUNK = 1000
+	// MAX_LAG is how far a paced clock may fall behind before it gives up
+	// catching up.
+	MAX_LAG = time.Second / 4
+	// DEFAULT_REFRESH is how often a running controller publishes its state.
+	DEFAULT_REFRESH = time.Second / 30
+)
+
+// CPUState is a copy of a CPU's registers and flags.
+type CPUState struct {
+	PC, SP, INC uint16
+	A, B, C, D  byte
+	M, XY, J    uint16
+	M1, M2      byte
+	X, Y        byte
+	J1, J2      byte
+	Zero, Carry bool
+	Sign        bool
+	LessThan    bool
+}
+
+func cpuState(c *NANDPU) CPUState {
+	return CPUState{
+		PC: c.PC.val, SP: c.SP.val, INC: c.INC.val,
+		A: c.RegA.val, B: c.RegB.val, C: c.RegC.val, D: c.RegD.val,
+		M: c.RegM.val, XY: c.RegXY.val, J: c.RegJ.val,
+		M1: c.RegM.Hi.ForceGet(), M2: c.RegM.Lo.ForceGet(),
+		X: c.RegXY.Hi.ForceGet(), Y: c.RegXY.Lo.ForceGet(),
+		J1: c.RegJ.Hi.ForceGet(), J2: c.RegJ.Lo.ForceGet(),
+		Zero: c.Zero, Carry: c.Carry, Sign: c.Sign, LessThan: c.LessThan,
+	}
+}
+
+// bankText describes the banks currently selected in each bank-switched window.
+func bankText(m *Machine) string {
+	text := ""
+	if m.ROMBank != nil {
+		text += fmt.Sprintf("ROM %d/%d ", m.ROMBank.Bank(), m.ROMBank.Banks())
+	}
+	if m.RAMBank != nil {
+		text += fmt.Sprintf("RAM %d/%d", m.RAMBank.Bank(), m.RAMBank.Banks())
+	}
+	if text == "" {
+		return "-"
+	}
+	return text
+}
+
+// RunState is a snapshot of a controller's system, taken on the controller's
+// goroutine. Nothing in it changes after it is published.
+type RunState struct {
+	Names      []string
+	Selected   int
+	Generation int // changes with the machine shown, so views of old devices can be dropped
+	Running    bool
+	Steps      uint64
+	Hz         float64 // clock achieved over the last second or so of running
+	HasNVRAM   bool
+
+	// Of the selected machine
+	CPU     CPUState
+	Bank    string
+	Mem     *[0x10000]byte
+	Devices []AttachedDevice
+	Panels  []func()
+}
+
+// Controller owns a System and runs it on its own goroutine. Everything
+// else talks to it through commands, which run between steps, and sees it
+// through the RunStates it publishes.
+type Controller struct {
+	// Refresh is the most often a running system is published.
+	Refresh time.Duration
+
+	cmds    chan func()
+	done    chan struct{}
+	publish func(*RunState)
+	build   func(rom string) (*System, error)
+
+	sys        *System
+	rom        string
+	selected   int
+	generation int
+	running    bool
+	steps      uint64
+	panels     []func() func()
+	published  time.Time
+
+	// The clock is paced by counting cycles from a start point, which is
+	// moved whenever a command could have changed the clock or the cycles.
+	hz         float64
+	paceStart  time.Time
+	paceCycles uint64
+	rateStart  time.Time
+	rateCycles uint64
+	rate       float64
+}
+
+// NewController starts a controller for sys, which build made from rom.
+// publish is called on the controller's goroutine with every new state.
+func NewController(sys *System, rom string, build func(rom string) (*System, error), publish func(*RunState)) *Controller {
+	c := &Controller{
+		Refresh: DEFAULT_REFRESH,
+		cmds:    make(chan func(), 64),
+		done:    make(chan struct{}),
+		publish: publish,
+		build:   build,
+		sys:     sys,
+		rom:     rom,
+	}
+	go c.loop()
+	c.cmds <- func() {}
+	return c
+}
+
+func (c *Controller) loop() {
+	defer close(c.done)
+	for {
+		var wait <-chan time.Time
+		if c.running {
+			d := c.pace()
+			if !c.running || time.Since(c.published) >= c.Refresh {
+				c.snapshot()
+			}
+			if c.running {
+				if d == 0 {
+					select {
+					case cmd, ok := <-c.cmds:
+						if !c.handle(cmd, ok) {
+							return
+						}
+					default:
+					}
+					continue
+				}
+				wait = time.After(d)
+			}
+		}
+
+		select {
+		case cmd, ok := <-c.cmds:
+			if !c.handle(cmd, ok) {
+				return
+			}
+		case <-wait:
+		}
+	}
+}
+
+func (c *Controller) handle(cmd func(), ok bool) bool {
+	if !ok {
+		return false
+	}
+	wasRunning := c.running
+	cmd()
+	c.paceStart, c.paceCycles = time.Now(), c.cycles()
+	if !wasRunning || !c.running {
+		c.rateStart, c.rateCycles = c.paceStart, c.paceCycles
+	}
+	c.snapshot()
+	return true
+}
+
+// cycles is the clock paced against: the fastest CPU's cycle count.
+func (c *Controller) cycles() uint64 {
+	var n uint64
+	for _, m := range c.sys.Machines {
+		n = max(n, m.CPU.Cycles)
+	}
+	return n
+}
+
+// pace runs the steps that are due and returns how long until the next one.
+func (c *Controller) pace() time.Duration {
+	if c.hz == 0 {
+		c.step(RUN_CHUNK)
+		return 0
+	}
+	now := time.Now()
+	// A cycle is due when it starts.
+	due := c.paceCycles + 1 + uint64(now.Sub(c.paceStart).Seconds()*c.hz)
+	if float64(due-min(due, c.cycles())) > 1+MAX_LAG.Seconds()*c.hz {
+		c.paceStart, c.paceCycles = now, c.cycles()
+		due = c.paceCycles + 1
+	}
+	for n := 0; c.running && c.cycles() < due; n++ {
+		if n == RUN_CHUNK {
+			return 0
+		}
+		c.step(1)
+	}
+	next := c.paceStart.Add(time.Duration(float64(c.cycles()-c.paceCycles) / c.hz * float64(time.Second)))
+	return max(time.Until(next), 1)
+}
+
+func (c *Controller) step(n int) {
+	for range n {
+		c.steps++
+		if !c.sys.Step() {
+			c.running = false
+			c.sys.SaveNVRAM()
+			return
+		}
+	}
+}
+
+func (c *Controller) snapshot() {
+	m := c.sys.Machines[c.selected]
+	s := &RunState{
+		Names:      c.sys.Names,
+		Selected:   c.selected,
+		Generation: c.generation,
+		Running:    c.running,
+		Steps:      c.steps,
+		HasNVRAM:   c.sys.HasNVRAM(),
+		CPU:        cpuState(m.CPU),
+		Bank:       bankText(m),
+		Mem:        new([0x10000]byte),
+		Devices:    m.Devices,
+	}
+	for addr := range 0x10000 {
+		// Bridged devices would need a request per byte.
+		if b, ok := m.CPU.Mem.region(uint16(addr)).(*Bridge); ok {
+			s.Mem[addr] = b.cached(uint16(addr))
+		} else {
+			s.Mem[addr] = m.CPU.Mem.Peek(uint16(addr))
+		}
+	}
+	for _, read := range c.panels {
+		s.Panels = append(s.Panels, read())
+	}
+	c.published = time.Now()
+	if !c.running {
+		c.rate = 0
+	} else if c.published.Sub(c.rateStart) >= time.Second {
+		cycles := c.cycles()
+		c.rate = float64(cycles-c.rateCycles) / c.published.Sub(c.rateStart).Seconds()
+		c.rateStart, c.rateCycles = c.published, cycles
+	}
+	s.Hz = c.rate
+	c.publish(s)
+}
+
+// rebuild replaces the system with a fresh one built from rom, flushing
+// battery-backed RAM first so the new machines load it back.
+func (c *Controller) rebuild(rom string) error {
+	c.sys.Close()
+	sys, err := c.build(rom)
+	if err != nil {
+		return err
+	}
+	c.sys = sys
+	c.rom = rom
+	c.selected = min(c.selected, len(sys.Machines)-1)
+	c.generation++
+	c.panels = nil
+	c.running = false
+	c.steps = 0
+	return nil
+}
+
+// Run starts running until the system stops or is paused.
+func (c *Controller) Run() { c.cmds <- func() { c.running = true } }
+
+// Pause stops running after the current step.
+func (c *Controller) Pause() { c.cmds <- func() { c.running = false } }
+
+// Step runs n steps and pauses.
+func (c *Controller) Step(n int) {
+	c.cmds <- func() {
+		c.running = false
+		c.step(n)
+	}
+}
+
+// Reset restarts the program, rebuilding the system when powerCycle is set.
+func (c *Controller) Reset(powerCycle bool) {
+	c.cmds <- func() {
+		if !powerCycle {
+			c.sys.Reset()
+			c.running = false
+			c.steps = 0
+			return
+		}
+		if err := c.rebuild(c.rom); err != nil {
+			Logger.Fatalf("Failed to rebuild machine: %v", err)
+		}
+	}
+}
+
+// Load rebuilds the system with a new ROM image, keeping the old image if
+// the new one can't be used.
+func (c *Controller) Load(rom string) {
+	c.cmds <- func() {
+		old := c.rom
+		if err := c.rebuild(rom); err != nil {
+			Logger.Printf("Failed to load %s: %v", rom, err)
+			if err := c.rebuild(old); err != nil {
+				Logger.Fatalf("Failed to rebuild machine: %v", err)
+			}
+		}
+	}
+}
+
+// Select chooses the machine whose state is published.
+func (c *Controller) Select(i int) {
+	c.cmds <- func() {
+		if i >= 0 && i < len(c.sys.Machines) && i != c.selected {
+			c.selected = i
+			c.generation++
+			c.panels = nil
+		}
+	}
+}
+
+// SetClock sets the clock to run at in Hz; 0 runs flat out.
+func (c *Controller) SetClock(hz float64) { c.cmds <- func() { c.hz = hz } }
+
+// SaveNVRAM writes battery-backed RAM back to its files.
+func (c *Controller) SaveNVRAM() { c.cmds <- func() { c.sys.SaveNVRAM() } }
+
+// Key types a byte on the selected machine's keyboard, if it has one.
+func (c *Controller) Key(b byte) {
+	c.cmds <- func() {
+		if k := c.sys.Machines[c.selected].Keyboard; k != nil {
+			k.Push(b)
+		}
+	}
+}
+
+// SetPanels sets the device panels to refresh with each published state. They
+// are dropped if the machine shown has changed since generation.
+func (c *Controller) SetPanels(generation int, panels []func() func()) {
+	c.cmds <- func() {
+		if generation == c.generation {
+			c.panels = panels
+		}
+	}
+}
+
+// Close stops the controller and closes its system.
+func (c *Controller) Close() {
+	close(c.cmds)
+	<-c.done
+	c.sys.Close()
+}
@@ -0,0 +1,75 @@
+package nandpu
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+// Device is a memory-mapped peripheral. Devices are ticked with the CPU's
+// cycles and reset along with it.
+type Device interface {
+	MemoryRegion
+	Ticker
+	Resetter
+}
+
+// InterruptSource is implemented by devices with an interrupt output. The
+// NANDPU has no interrupt input, so the machine only logs them.
+type InterruptSource interface {
+	SetInterruptHandler(fn func())
+}
+
+// Snapshotter is implemented by devices whose state can be saved and restored.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// DeviceFactory builds a device from its region in a machine config. The
+// machine's CPU is already set up, and earlier regions are already attached.
+type DeviceFactory func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error)
+
+type deviceType struct {
+	size    uint16
+	factory DeviceFactory
+}
+
+var deviceTypes = map[string]deviceType{}
+
+// RegisterDevice makes a device available as a region type in machine
+// configs. Size is the default size of its register block. Devices sit above
+// memory unless the region gives a priority. Call it from an init function in
+// the device's own file, as timer.go does; settings beyond the common region
+// fields come from the region's options table.
+func RegisterDevice(name string, size uint16, factory DeviceFactory) {
+	if _, ok := deviceTypes[name]; ok {
+		panic(fmt.Sprintf("device type %q registered twice", name))
+	}
+	deviceTypes[name] = deviceType{size: size, factory: factory}
+	if _, ok := defaultPriorities[name]; !ok {
+		defaultPriorities[name] = 3
+	}
+}
+
+// DeviceTypes lists the registered device types.
+func DeviceTypes() []string {
+	var names []string
+	for name := range deviceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// encodeState and decodeState save device state structs for snapshots.
+func encodeState(state any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(state)
+	return buf.Bytes(), err
+}
+
+func decodeState(data []byte, state any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(state)
+}
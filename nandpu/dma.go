@@ -0,0 +1,211 @@
+package nandpu
+
+import "fmt"
+
+// DMA register offsets from the device's base address.
+const (
+	DMA_SRC_LO uint16 = 0x0
+	DMA_SRC_HI uint16 = 0x1
+	DMA_DST_LO uint16 = 0x2
+	DMA_DST_HI uint16 = 0x3
+	DMA_LEN_LO uint16 = 0x4 // bytes to transfer; 0 transfers 65536
+	DMA_LEN_HI uint16 = 0x5
+	DMA_FILL   uint16 = 0x6 // value written in fill mode
+	DMA_CTRL   uint16 = 0x7 // bit 0 start, bit 1 fill, bit 2 interrupt enable, bit 3 fixed source, bit 4 fixed destination
+	DMA_STATUS uint16 = 0x8 // bit 0 busy, bit 1 done; write 1 to clear done
+
+	DMA_SIZE uint16 = 0x10
+
+	// Bus cycles stolen from the CPU per byte copied or filled.
+	dmaCopyCycles = 2
+	dmaFillCycles = 1
+)
+
+const (
+	dmaCtrlStart byte = 1 << iota
+	dmaCtrlFill
+	dmaCtrlIRQ
+	dmaCtrlFixedSrc
+	dmaCtrlFixedDst
+)
+
+const (
+	dmaStatusBusy byte = 1 << iota
+	dmaStatusDone
+)
+
+// DMA copies or fills memory through the CPU's memory map, so transfers can
+// target devices as well as RAM. Setting the start bit halts the CPU until the
+// transfer is done; the stolen cycles are added to the cycle counter and
+// devices keep ticking meanwhile. A fixed address makes a device register
+// usable as a source or destination port.
+type DMA struct {
+	base uint16
+	cpu  *NANDPU
+
+	src    uint16
+	dst    uint16
+	length uint16
+	fill   byte
+	ctrl   byte
+	status byte
+
+	Bytes  uint64 // bytes moved since power on
+	Stolen uint64 // cycles taken from the CPU since power on
+
+	OnInterrupt func()
+}
+
+func init() {
+	RegisterDevice("dma", DMA_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.DMA != nil {
+			return nil, fmt.Errorf("only one dma controller is supported")
+		}
+		m.DMA = NewDMA(r.Base, m.CPU)
+		return m.DMA, nil
+	})
+}
+
+func NewDMA(base uint16, cpu *NANDPU) *DMA { return &DMA{base: base, cpu: cpu} }
+
+func (d *DMA) Read(addr uint16) byte { return d.Peek(addr) }
+
+func (d *DMA) Peek(addr uint16) byte {
+	switch addr - d.base {
+	case DMA_SRC_LO:
+		return byte(d.src)
+	case DMA_SRC_HI:
+		return byte(d.src >> 8)
+	case DMA_DST_LO:
+		return byte(d.dst)
+	case DMA_DST_HI:
+		return byte(d.dst >> 8)
+	case DMA_LEN_LO:
+		return byte(d.length)
+	case DMA_LEN_HI:
+		return byte(d.length >> 8)
+	case DMA_FILL:
+		return d.fill
+	case DMA_CTRL:
+		return d.ctrl
+	case DMA_STATUS:
+		return d.status
+	}
+	return 0x00
+}
+
+func (d *DMA) Write(addr uint16, val byte) {
+	switch addr - d.base {
+	case DMA_CTRL:
+		d.ctrl = val &^ dmaCtrlStart
+		if val&dmaCtrlStart != 0 && d.status&dmaStatusBusy == 0 {
+			d.transfer()
+		}
+	case DMA_STATUS:
+		d.status &^= val & dmaStatusDone
+	default:
+		d.Poke(addr, val)
+	}
+}
+
+// Poke sets registers without starting a transfer.
+func (d *DMA) Poke(addr uint16, val byte) {
+	switch addr - d.base {
+	case DMA_SRC_LO:
+		d.src = (d.src & 0xFF00) | uint16(val)
+	case DMA_SRC_HI:
+		d.src = (d.src & 0x00FF) | (uint16(val) << 8)
+	case DMA_DST_LO:
+		d.dst = (d.dst & 0xFF00) | uint16(val)
+	case DMA_DST_HI:
+		d.dst = (d.dst & 0x00FF) | (uint16(val) << 8)
+	case DMA_LEN_LO:
+		d.length = (d.length & 0xFF00) | uint16(val)
+	case DMA_LEN_HI:
+		d.length = (d.length & 0x00FF) | (uint16(val) << 8)
+	case DMA_FILL:
+		d.fill = val
+	case DMA_CTRL:
+		d.ctrl = val &^ dmaCtrlStart
+	case DMA_STATUS:
+		d.status = val
+	}
+}
+
+// transfer runs the whole transfer, leaving the address and length registers
+// where it stopped. It ends early if the CPU faults or is reset, and the reset
+// happens once the store that started the transfer has finished.
+func (d *DMA) transfer() {
+	d.status = (d.status &^ dmaStatusDone) | dmaStatusBusy
+	fill := d.ctrl&dmaCtrlFill != 0
+	cost := uint64(dmaCopyCycles)
+	if fill {
+		cost = dmaFillCycles
+	}
+	src, dst, start := d.src, d.dst, d.cpu.Cycles
+
+	var moved uint64
+	for remaining := int(d.length-1) + 1; remaining > 0; remaining-- {
+		val := d.fill
+		if !fill {
+			val = d.cpu.Mem.Read(d.src)
+			if d.ctrl&dmaCtrlFixedSrc == 0 {
+				d.src++
+			}
+		}
+		d.cpu.Mem.Write(d.dst, val)
+		if d.ctrl&dmaCtrlFixedDst == 0 {
+			d.dst++
+		}
+		d.length--
+		moved++
+
+		d.Stolen += cost
+		d.cpu.Stall(cost)
+		if d.status&dmaStatusBusy == 0 || d.cpu.Fault != nil || d.cpu.resetPending {
+			break
+		}
+	}
+	d.Bytes += moved
+
+	if fill {
+		Logger.Printf("DMA fill 0x%02X -> 0x%04X, %d bytes in %d cycles", d.fill, dst, moved, d.cpu.Cycles-start)
+	} else {
+		Logger.Printf("DMA copy 0x%04X -> 0x%04X, %d bytes in %d cycles", src, dst, moved, d.cpu.Cycles-start)
+	}
+	if d.status&dmaStatusBusy == 0 || d.cpu.resetPending {
+		return
+	}
+	d.status = dmaStatusDone
+	if d.ctrl&dmaCtrlIRQ != 0 && d.OnInterrupt != nil {
+		d.OnInterrupt()
+	}
+}
+
+func (d *DMA) Reset() {
+	*d = DMA{base: d.base, cpu: d.cpu, Bytes: d.Bytes, Stolen: d.Stolen, OnInterrupt: d.OnInterrupt}
+}
+
+// Tick does nothing: transfers run to completion as soon as they start.
+func (d *DMA) Tick(cycles uint64) {}
+
+func (d *DMA) SetInterruptHandler(fn func()) { d.OnInterrupt = fn }
+
+type dmaState struct {
+	Src, Dst, Length   uint16
+	Fill, Ctrl, Status byte
+	Bytes, Stolen      uint64
+}
+
+func (d *DMA) Snapshot() ([]byte, error) {
+	return encodeState(dmaState{d.src, d.dst, d.length, d.fill, d.ctrl, d.status, d.Bytes, d.Stolen})
+}
+
+func (d *DMA) Restore(data []byte) error {
+	var s dmaState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	d.src, d.dst, d.length, d.fill, d.ctrl, d.status, d.Bytes, d.Stolen = s.Src, s.Dst, s.Length, s.Fill, s.Ctrl, s.Status, s.Bytes, s.Stolen
+	return nil
+}
@@ -0,0 +1,87 @@
+package nandpu
+
+import "testing"
+
+func startDMA(d *DMA, src, dst, length uint16, fill, ctrl byte) {
+	for _, r := range []struct {
+		off uint16
+		val byte
+	}{
+		{DMA_SRC_LO, byte(src)}, {DMA_SRC_HI, byte(src >> 8)},
+		{DMA_DST_LO, byte(dst)}, {DMA_DST_HI, byte(dst >> 8)},
+		{DMA_LEN_LO, byte(length)}, {DMA_LEN_HI, byte(length >> 8)},
+		{DMA_FILL, fill},
+		{DMA_CTRL, ctrl | dmaCtrlStart},
+	} {
+		d.Write(DMA_BASE+r.off, r.val)
+	}
+}
+
+func TestDMACopyStealsCycles(t *testing.T) {
+	m := newMachine(t, nil, MachineOptions{DMA: true})
+	c, d := m.CPU, m.DMA
+	for i := range 8 {
+		c.Mem.Poke(0x8000+uint16(i), byte(0x10+i))
+	}
+
+	start := c.Cycles
+	startDMA(d, 0x8000, 0x9000, 8, 0, 0)
+	for i := range 8 {
+		if val := c.Mem.Peek(0x9000 + uint16(i)); val != byte(0x10+i) {
+			t.Errorf("byte %d copied as %02X, want %02X", i, val, 0x10+i)
+		}
+	}
+	if got, want := c.Cycles-start, uint64(8*dmaCopyCycles); got != want || d.Stolen != want {
+		t.Errorf("copy took %d cycles (%d stolen), want %d", got, d.Stolen, want)
+	}
+	if d.Peek(DMA_BASE+DMA_STATUS) != dmaStatusDone || d.Peek(DMA_BASE+DMA_SRC_LO) != 0x08 || d.Peek(DMA_BASE+DMA_LEN_LO) != 0 {
+		t.Errorf("status %02X, source low %02X, length %d after the copy, want done, 08 and 0",
+			d.Peek(DMA_BASE+DMA_STATUS), d.Peek(DMA_BASE+DMA_SRC_LO), d.Peek(DMA_BASE+DMA_LEN_LO))
+	}
+	d.Write(DMA_BASE+DMA_STATUS, dmaStatusDone)
+	if d.Peek(DMA_BASE+DMA_STATUS) != 0 {
+		t.Error("writing 1 didn't clear done")
+	}
+}
+
+func TestDMAFill(t *testing.T) {
+	m := newMachine(t, nil, MachineOptions{DMA: true})
+	c, d := m.CPU, m.DMA
+	fired := 0
+	d.SetInterruptHandler(func() { fired++ })
+
+	c.Mem.Poke(0x80FF, 0)
+	c.Mem.Poke(0x822C, 0)
+
+	start := c.Cycles
+	startDMA(d, 0, 0x8100, 300, 0xAB, dmaCtrlFill|dmaCtrlIRQ)
+	for _, addr := range []uint16{0x80FF, 0x822C} {
+		if c.Mem.Peek(addr) != 0 {
+			t.Errorf("fill overran to 0x%04X", addr)
+		}
+	}
+	for _, addr := range []uint16{0x8100, 0x8200, 0x822B} {
+		if val := c.Mem.Peek(addr); val != 0xAB {
+			t.Errorf("0x%04X filled with %02X, want AB", addr, val)
+		}
+	}
+	if got := c.Cycles - start; got != 300*dmaFillCycles {
+		t.Errorf("fill took %d cycles, want %d", got, 300*dmaFillCycles)
+	}
+	if fired != 1 {
+		t.Errorf("interrupt raised %d times, want 1", fired)
+	}
+}
+
+func TestDMAFixedDestination(t *testing.T) {
+	m := newMachine(t, nil, MachineOptions{DMA: true})
+	c, d := m.CPU, m.DMA
+	for i := range 4 {
+		c.Mem.Poke(0x8000+uint16(i), byte(i+1))
+	}
+	c.Mem.Poke(0x9001, 0)
+	startDMA(d, 0x8000, 0x9000, 4, 0, dmaCtrlFixedDst)
+	if val := c.Mem.Peek(0x9000); val != 4 || c.Mem.Peek(0x9001) != 0 {
+		t.Errorf("fixed destination holds %02X, want the last byte 04 and nothing after it", val)
+	}
+}
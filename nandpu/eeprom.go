@@ -0,0 +1,270 @@
+package nandpu
+
+import (
+	"os"
+)
+
+const (
+	EEPROM_PAGE_SIZE = 64
+
+	// Byte load window (tBLC) and write cycle time (tWC), in microseconds.
+	eepromLoadMicros  = 150
+	eepromWriteMicros = 10000
+
+	// Rated endurance of the AT28C256, in write cycles per byte.
+	eepromEndurance = 10000
+)
+
+// Steps of the software data protection command sequences.
+const (
+	sdpIdle = iota
+	sdpAA
+	sdpAA55
+	sdpDisable80
+	sdpDisableAA
+	sdpDisableAA55
+)
+
+// EEPROM emulates in-circuit writes to an AT28C256: page loads, the write
+// cycle with DATA# polling and toggle bit status, and software data
+// protection. Command sequence writes are held until the sequence completes
+// and never reach the array. A sequence that is broken off, or not finished
+// within the byte load window, lets its writes through as ordinary ones.
+type EEPROM struct {
+	data   []byte
+	base   uint16
+	writes []uint32
+	path   string
+	dirty  bool
+
+	sdp      bool
+	unlocked bool
+	seq      int
+	held     []eepromWrite // writes of the sequence so far
+	seqWait  uint64        // cycles left to finish the sequence
+
+	page     int // page being loaded, -1 when idle
+	pageData [EEPROM_PAGE_SIZE]byte
+	pageMask uint64
+	loading  uint64
+	busy     uint64
+	lastByte byte
+	toggle   byte
+
+	LoadCycles  uint64
+	WriteCycles uint64
+	OnRead      func(addr uint16)
+	OnWrite     func(addr uint16, value byte)
+}
+
+type eepromWrite struct {
+	offset uint16
+	val    byte
+}
+
+// NewEEPROM creates an EEPROM holding data, with write timings scaled to the
+// CPU clock. When path is non-empty the modified image is written back there
+// by Save.
+func NewEEPROM(base, size uint16, data []byte, path string, clockHz uint64) *EEPROM {
+	e := &EEPROM{
+		data:        make([]byte, size),
+		base:        base,
+		writes:      make([]uint32, size),
+		path:        path,
+		page:        -1,
+		LoadCycles:  max(clockHz*eepromLoadMicros/1000000, 1),
+		WriteCycles: max(clockHz*eepromWriteMicros/1000000, 1),
+	}
+	copy(e.data, data)
+	return e
+}
+
+func (e *EEPROM) Read(addr uint16) byte {
+	if e.OnRead != nil {
+		e.OnRead(addr)
+	}
+	if e.page >= 0 || e.busy > 0 {
+		// DATA# polling on bit 7, toggle bit on bit 6.
+		e.toggle ^= 0x40
+		return (^e.lastByte & 0x80) | e.toggle
+	}
+	return e.data[addr-e.base]
+}
+
+// Peek shows the array contents even during a write cycle.
+func (e *EEPROM) Peek(addr uint16) byte { return e.data[addr-e.base] }
+
+// Poke patches the array without a write cycle or wear.
+func (e *EEPROM) Poke(addr uint16, val byte) {
+	e.data[addr-e.base] = val
+	e.dirty = true
+}
+
+func (e *EEPROM) Write(addr uint16, val byte) {
+	if e.OnWrite != nil {
+		e.OnWrite(addr, val)
+	}
+	if e.busy > 0 {
+		Logger.Printf("EEPROM write to 0x%04X ignored during write cycle", addr)
+		return
+	}
+
+	offset := addr - e.base
+	if e.command(offset, val) {
+		return
+	}
+	e.load(offset, val)
+}
+
+// load adds a byte to the page being loaded, starting one if none is.
+func (e *EEPROM) load(offset uint16, val byte) {
+	addr := e.base + offset
+	if e.sdp && !e.unlocked {
+		Logger.Printf("EEPROM write to 0x%04X blocked by software data protection", addr)
+		return
+	}
+
+	page := int(offset) / EEPROM_PAGE_SIZE
+	if e.page < 0 {
+		e.page = page
+		e.pageMask = 0
+	} else if page != e.page {
+		Logger.Printf("EEPROM write to 0x%04X outside page being loaded, ignored", addr)
+		return
+	}
+	e.pageData[offset%EEPROM_PAGE_SIZE] = val
+	e.pageMask |= 1 << (offset % EEPROM_PAGE_SIZE)
+	e.lastByte = val
+	e.loading = e.LoadCycles
+}
+
+// command advances the software data protection state machine and reports
+// whether the write was held as part of a command sequence.
+func (e *EEPROM) command(offset uint16, val byte) bool {
+	next := sdpIdle
+	switch {
+	case e.seq == sdpIdle && offset == 0x5555 && val == 0xAA:
+		next = sdpAA
+	case e.seq == sdpAA && offset == 0x2AAA && val == 0x55:
+		next = sdpAA55
+	case e.seq == sdpAA55 && offset == 0x5555 && val == 0xA0:
+		e.sdp = true
+		e.unlocked = true
+	case e.seq == sdpAA55 && offset == 0x5555 && val == 0x80:
+		next = sdpDisable80
+	case e.seq == sdpDisable80 && offset == 0x5555 && val == 0xAA:
+		next = sdpDisableAA
+	case e.seq == sdpDisableAA && offset == 0x2AAA && val == 0x55:
+		next = sdpDisableAA55
+	case e.seq == sdpDisableAA55 && offset == 0x5555 && val == 0x20:
+		e.sdp = false
+		Logger.Println("EEPROM software data protection disabled")
+	case offset == 0x5555 && val == 0xAA:
+		// A new sequence starts part way through another.
+		e.abandon()
+		next = sdpAA
+	default:
+		e.abandon()
+		return false
+	}
+	e.seq = next
+	if next == sdpIdle {
+		e.held = e.held[:0]
+	} else {
+		e.held = append(e.held, eepromWrite{offset, val})
+		e.seqWait = e.LoadCycles
+	}
+	return true
+}
+
+// abandon ends a command sequence that was broken off, loading the writes
+// held for it as ordinary writes.
+func (e *EEPROM) abandon() {
+	held := e.held
+	e.seq, e.held = sdpIdle, nil
+	for _, w := range held {
+		e.load(w.offset, w.val)
+	}
+}
+
+func (e *EEPROM) Tick(cycles uint64) {
+	if e.seq != sdpIdle {
+		if e.seqWait > cycles {
+			e.seqWait -= cycles
+		} else {
+			e.abandon()
+		}
+	}
+	if e.page >= 0 {
+		if e.loading > cycles {
+			e.loading -= cycles
+			return
+		}
+		cycles -= e.loading
+		e.loading = 0
+		e.busy = e.WriteCycles
+		e.commitPage()
+	}
+	if e.busy > 0 {
+		if e.busy > cycles {
+			e.busy -= cycles
+			return
+		}
+		e.busy = 0
+	}
+}
+
+// commitPage latches the loaded bytes into the array as the write cycle starts.
+// They only become readable once the cycle has finished.
+func (e *EEPROM) commitPage() {
+	start := e.page * EEPROM_PAGE_SIZE
+	count := 0
+	for i := range EEPROM_PAGE_SIZE {
+		if e.pageMask&(1<<i) == 0 {
+			continue
+		}
+		e.data[start+i] = e.pageData[i]
+		e.writes[start+i]++
+		count++
+	}
+	Logger.Printf("EEPROM page write at 0x%04X (%d bytes)", e.base+uint16(start), count)
+
+	e.page = -1
+	e.pageMask = 0
+	e.unlocked = false
+	e.dirty = true
+}
+
+// Save writes the image back to its file if it has been modified.
+func (e *EEPROM) Save() error {
+	if e.path == "" || !e.dirty {
+		return nil
+	}
+	if err := os.WriteFile(e.path, e.data, 0644); err != nil {
+		return err
+	}
+	e.dirty = false
+	Logger.Printf("Saved EEPROM image to %s", e.path)
+	return nil
+}
+
+// WearReport logs how often the most-written byte has been programmed.
+func (e *EEPROM) WearReport() {
+	var total, worst uint64
+	worstAddr := 0
+	for i, n := range e.writes {
+		total += uint64(n)
+		if uint64(n) > worst {
+			worst = uint64(n)
+			worstAddr = i
+		}
+	}
+	if total == 0 {
+		return
+	}
+	Logger.Printf("EEPROM wear: %d byte writes, most written 0x%04X (%d of %d rated cycles)",
+		total, e.base+uint16(worstAddr), worst, eepromEndurance)
+}
+
+// Wear returns the number of times the byte at addr has been programmed.
+func (e *EEPROM) Wear(addr uint16) uint32 { return e.writes[addr-e.base] }
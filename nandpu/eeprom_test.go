@@ -0,0 +1,51 @@
+package nandpu
+
+import "testing"
+
+// program writes vals to the EEPROM and waits for the write cycle to finish.
+func program(e *EEPROM, addr uint16, vals ...byte) {
+	for _, val := range vals {
+		e.Write(addr, val)
+	}
+	e.Tick(e.LoadCycles + e.WriteCycles)
+}
+
+func TestEEPROMBrokenSequenceIsWritten(t *testing.T) {
+	e := NewEEPROM(0, 0x8000, nil, "", 1000000)
+	program(e, 0x5555, 0xAA)
+	if e.Peek(0x5555) != 0xAA {
+		t.Errorf("0x5555 = %02X after an unfinished sequence, want AA", e.Peek(0x5555))
+	}
+
+	e.Write(0x5555, 0xBB)
+	e.Write(0x5555, 0xAA)
+	e.Write(0x5556, 0x12)
+	e.Tick(e.LoadCycles + e.WriteCycles)
+	if e.Peek(0x5555) != 0xAA || e.Peek(0x5556) != 0x12 {
+		t.Errorf("0x5555 = %02X and 0x5556 = %02X after a broken off sequence, want AA and 12", e.Peek(0x5555), e.Peek(0x5556))
+	}
+}
+
+func TestEEPROMProtection(t *testing.T) {
+	e := NewEEPROM(0, 0x8000, []byte{0x200: 0xFF}, "", 1000000)
+	e.Write(0x5555, 0xAA)
+	e.Write(0x2AAA, 0x55)
+	e.Write(0x5555, 0xA0)
+	program(e, 0x200, 0x07)
+	if e.Peek(0x200) != 0x07 || e.Peek(0x5555) != 0 || e.Peek(0x2AAA) != 0 {
+		t.Errorf("after enabling protection 0x200 = %02X, 0x5555 = %02X and 0x2AAA = %02X, want 07, 00 and 00",
+			e.Peek(0x200), e.Peek(0x5555), e.Peek(0x2AAA))
+	}
+
+	program(e, 0x200, 0x09)
+	if e.Peek(0x200) != 0x07 {
+		t.Errorf("unprotected write reached the array: 0x200 = %02X", e.Peek(0x200))
+	}
+	e.Write(0x5555, 0xAA)
+	e.Write(0x2AAA, 0x55)
+	e.Write(0x5555, 0xA0)
+	program(e, 0x200, 0x09)
+	if e.Peek(0x200) != 0x09 {
+		t.Errorf("0x200 = %02X after a protected write, want 09", e.Peek(0x200))
+	}
+}
@@ -0,0 +1,145 @@
+package nandpu
+
+// instFormat describes the bytes of an instruction: its length and which
+// operand bytes name 8-bit or 16-bit registers, as bit masks by byte offset.
+type instFormat struct {
+	length      uint16
+	reg8, reg16 uint8
+}
+
+var instFormats = func() [256]instFormat {
+	var f [256]instFormat
+	for i := range f {
+		f[i] = instFormat{length: 1}
+	}
+	for _, op := range []byte{OP_ADD, OP_SUB, OP_INC, OP_DEC, OP_NAND, OP_SHR, OP_SHL, OP_LDM, OP_STO, OP_PUSH, OP_POP} {
+		f[op] = instFormat{length: 2, reg8: 1 << 1}
+	}
+	f[OP_LDI] = instFormat{length: 3, reg8: 1 << 2}
+	f[OP_LDMI] = instFormat{length: 4, reg8: 1 << 3}
+	f[OP_STOI] = instFormat{length: 4, reg8: 1 << 1}
+	f[OP_MOV8] = instFormat{length: 3, reg8: 1<<1 | 1<<2}
+	f[OP_MOV16] = instFormat{length: 3, reg16: 1<<1 | 1<<2}
+	for _, op := range []byte{OP_JMPI, OP_CALI, OP_BZSI, OP_BZCI, OP_BCSI, OP_BCCI, OP_BSSI, OP_BSCI, OP_BLSI, OP_BLCI} {
+		f[op] = instFormat{length: 3}
+	}
+	return f
+}()
+
+// decodedInst is an instruction decoded once, with its operand registers
+// looked up by byte offset.
+type decodedInst struct {
+	valid  bool
+	length uint16
+	bytes  [4]byte
+	reg8   [4]Reg8Like
+	reg16  [4]Reg16Like
+}
+
+// ICache holds decoded instructions by address. Only code in plain ROM and RAM
+// without read hooks is cached, since fetching it has no side effects; the
+// memory reports every change to its contents so stale entries are dropped.
+type ICache struct {
+	cpu     *NANDPU
+	pages   [0x10000 / PAGE_SIZE]*[PAGE_SIZE]decodedInst
+	watched map[MemoryRegion]bool
+
+	Hits, Misses, Invalidations uint64
+}
+
+// contentWatcher is implemented by memory that can report changes to its
+// contents, however they are made.
+type contentWatcher interface {
+	watch(fn func(addr uint16))
+}
+
+// EnableICache turns on the instruction cache.
+func (c *NANDPU) EnableICache() {
+	c.ICache = &ICache{cpu: c, watched: map[MemoryRegion]bool{}}
+}
+
+// lookup returns the decoded instruction at addr, decoding it on a miss, or
+// nil if the code there can't be cached.
+func (ic *ICache) lookup(addr uint16) *decodedInst {
+	page := ic.pages[addr/PAGE_SIZE]
+	if page != nil && page[addr%PAGE_SIZE].valid {
+		ic.Hits++
+		return &page[addr%PAGE_SIZE]
+	}
+	ic.Misses++
+
+	mem := &ic.cpu.Mem
+	if !ic.cacheable(mem.region(addr)) {
+		return nil
+	}
+	format := instFormats[mem.region(addr).Peek(addr)]
+	if int(addr)+int(format.length) > 0x10000 {
+		return nil
+	}
+	inst := decodedInst{valid: true, length: format.length}
+	for i := range format.length {
+		region := mem.region(addr + i)
+		if !ic.cacheable(region) || !mem.Allowed(addr+i, PERM_X) {
+			return nil
+		}
+		inst.bytes[i] = region.Peek(addr + i)
+	}
+
+	// Out of range register numbers are left for execution to trip over.
+	for i := range format.length {
+		idx := inst.bytes[i]
+		if format.reg8&(1<<i) != 0 && int(idx) < len(ic.cpu.Reg8List) {
+			inst.reg8[i] = ic.cpu.Reg8List[idx]
+		}
+		if format.reg16&(1<<i) != 0 && int(idx) < len(ic.cpu.Reg16List) {
+			inst.reg16[i] = ic.cpu.Reg16List[idx]
+		}
+	}
+	for i := range format.length {
+		region := mem.region(addr + i)
+		if !ic.watched[region] {
+			region.(contentWatcher).watch(ic.invalidate)
+			ic.watched[region] = true
+		}
+	}
+
+	if page == nil {
+		page = new([PAGE_SIZE]decodedInst)
+		ic.pages[addr/PAGE_SIZE] = page
+	}
+	page[addr%PAGE_SIZE] = inst
+	return &page[addr%PAGE_SIZE]
+}
+
+func (ic *ICache) cacheable(region MemoryRegion) bool {
+	switch r := region.(type) {
+	case *ROM:
+		return r.OnRead == nil
+	case *RAM:
+		return r.OnRead == nil
+	}
+	return false
+}
+
+// invalidate drops every cached instruction that includes addr.
+func (ic *ICache) invalidate(addr uint16) {
+	c := ic.cpu
+	if c.cur != nil && addr-c.curPC < c.cur.length {
+		// The running instruction reads any remaining bytes from memory.
+		c.cur = nil
+	}
+	for start := int(addr) - 3; start <= int(addr); start++ {
+		if start < 0 {
+			continue
+		}
+		page := ic.pages[start/PAGE_SIZE]
+		if page == nil {
+			continue
+		}
+		inst := &page[start%PAGE_SIZE]
+		if inst.valid && start+int(inst.length) > int(addr) {
+			inst.valid = false
+			ic.Invalidations++
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package nandpu
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Keyboard register offsets from the keyboard's base address.
+const (
+	KBD_DATA   uint16 = 0x0 // reading pops the next byte from the FIFO, 0x00 if empty
+	KBD_STATUS uint16 = 0x1 // bit 0 data available, bit 1 overflow; write 1 to clear overflow
+	KBD_CTRL   uint16 = 0x2 // bit 0 interrupt enable
+	KBD_COUNT  uint16 = 0x3 // number of bytes waiting in the FIFO
+
+	KBD_SIZE uint16 = 0x4
+
+	kbdFIFOSize = 16
+)
+
+const (
+	kbdStatusAvailable byte = 1 << iota
+	kbdStatusOverflow
+)
+
+// Keyboard is an ASCII keyboard controller with a small FIFO. Keys can be
+// pushed directly (as the GUI does, and which may overflow the FIFO) or queued
+// as host input, which is fed in one byte at a time whenever the FIFO is empty.
+type Keyboard struct {
+	mu       sync.Mutex
+	base     uint16
+	fifo     []byte
+	pending  []byte
+	overflow bool
+	ctrl     byte
+	irq      bool
+
+	OnInterrupt func()
+}
+
+func init() {
+	RegisterDevice("keyboard", KBD_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.Keyboard != nil {
+			return nil, fmt.Errorf("only one keyboard is supported")
+		}
+		m.Keyboard = NewKeyboard(r.Base)
+		return m.Keyboard, nil
+	})
+}
+
+func NewKeyboard(base uint16) *Keyboard { return &Keyboard{base: base} }
+
+func (k *Keyboard) Read(addr uint16) byte {
+	if addr-k.base != KBD_DATA {
+		return k.Peek(addr)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.fifo) == 0 {
+		return 0x00
+	}
+	val := k.fifo[0]
+	k.fifo = k.fifo[1:]
+	return val
+}
+
+// Peek shows the next byte in the FIFO without popping it.
+func (k *Keyboard) Peek(addr uint16) byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch addr - k.base {
+	case KBD_DATA:
+		if len(k.fifo) == 0 {
+			return 0x00
+		}
+		return k.fifo[0]
+	case KBD_STATUS:
+		return k.status()
+	case KBD_CTRL:
+		return k.ctrl
+	case KBD_COUNT:
+		return byte(len(k.fifo))
+	}
+	return 0x00
+}
+
+func (k *Keyboard) Poke(addr uint16, val byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if addr-k.base == KBD_CTRL {
+		k.ctrl = val
+	}
+}
+
+func (k *Keyboard) status() byte {
+	var status byte
+	if len(k.fifo) > 0 {
+		status |= kbdStatusAvailable
+	}
+	if k.overflow {
+		status |= kbdStatusOverflow
+	}
+	return status
+}
+
+func (k *Keyboard) Write(addr uint16, val byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch addr - k.base {
+	case KBD_STATUS:
+		if val&kbdStatusOverflow != 0 {
+			k.overflow = false
+		}
+	case KBD_CTRL:
+		k.ctrl = val
+	}
+}
+
+// Push adds a key straight into the FIFO, setting the overflow flag if full.
+func (k *Keyboard) Push(val byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.push(val)
+}
+
+func (k *Keyboard) push(val byte) {
+	if len(k.fifo) >= kbdFIFOSize {
+		k.overflow = true
+		return
+	}
+	k.fifo = append(k.fifo, val)
+	k.irq = true
+}
+
+// Queue adds host input to be typed into the FIFO as the program drains it.
+func (k *Keyboard) Queue(data []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.pending = append(k.pending, data...)
+}
+
+// Feed queues everything read from r in the background, e.g. from stdin.
+func (k *Keyboard) Feed(r io.Reader) {
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				k.Queue(buf[:n])
+			}
+			if err != nil {
+				if err != io.EOF {
+					Logger.Printf("Keyboard input error: %v", err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (k *Keyboard) Tick(cycles uint64) {
+	k.mu.Lock()
+	if len(k.fifo) == 0 && len(k.pending) > 0 {
+		k.push(k.pending[0])
+		k.pending = k.pending[1:]
+	}
+	fire := k.irq && k.ctrl&0x01 != 0
+	k.irq = false
+	k.mu.Unlock()
+
+	if fire && k.OnInterrupt != nil {
+		k.OnInterrupt()
+	}
+}
+
+func (k *Keyboard) Reset() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.fifo = nil
+	k.overflow = false
+	k.ctrl = 0
+	k.irq = false
+}
+
+func (k *Keyboard) SetInterruptHandler(fn func()) { k.OnInterrupt = fn }
+
+type keyboardState struct {
+	FIFO, Pending []byte
+	Overflow, IRQ bool
+	Ctrl          byte
+}
+
+func (k *Keyboard) Snapshot() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return encodeState(keyboardState{k.fifo, k.pending, k.overflow, k.irq, k.ctrl})
+}
+
+func (k *Keyboard) Restore(data []byte) error {
+	var s keyboardState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.fifo, k.pending, k.overflow, k.irq, k.ctrl = s.FIFO, s.Pending, s.Overflow, s.IRQ, s.Ctrl
+	return nil
+}
@@ -0,0 +1,50 @@
+package nandpu
+
+import "testing"
+
+func TestKeyboardFIFO(t *testing.T) {
+	k := NewKeyboard(KEYBOARD_BASE)
+	for i := range kbdFIFOSize + 1 {
+		k.Push(byte('a' + i))
+	}
+	if status := k.Read(KEYBOARD_BASE + KBD_STATUS); status != kbdStatusAvailable|kbdStatusOverflow {
+		t.Errorf("status 0x%02X after overfilling the FIFO, want 0x%02X", status, kbdStatusAvailable|kbdStatusOverflow)
+	}
+	if k.Peek(KEYBOARD_BASE+KBD_DATA) != 'a' || k.Read(KEYBOARD_BASE+KBD_COUNT) != kbdFIFOSize {
+		t.Error("Peek popped the FIFO")
+	}
+	for i := range kbdFIFOSize {
+		if val := k.Read(KEYBOARD_BASE + KBD_DATA); val != byte('a'+i) {
+			t.Fatalf("read %q from the FIFO, want %q", val, 'a'+i)
+		}
+	}
+	if k.Read(KEYBOARD_BASE+KBD_DATA) != 0 || k.Read(KEYBOARD_BASE+KBD_STATUS) != kbdStatusOverflow {
+		t.Error("empty FIFO doesn't read 0x00 with only the overflow flag set")
+	}
+	k.Write(KEYBOARD_BASE+KBD_STATUS, kbdStatusOverflow)
+	if k.Read(KEYBOARD_BASE+KBD_STATUS) != 0 {
+		t.Error("writing 1 didn't clear the overflow flag")
+	}
+}
+
+func TestKeyboardQueuedInput(t *testing.T) {
+	k := NewKeyboard(KEYBOARD_BASE)
+	interrupts := 0
+	k.SetInterruptHandler(func() { interrupts++ })
+	k.Write(KEYBOARD_BASE+KBD_CTRL, 0x01)
+	k.Queue([]byte("hi"))
+
+	k.Tick(1)
+	k.Tick(1)
+	if k.Read(KEYBOARD_BASE+KBD_COUNT) != 1 {
+		t.Error("queued input was typed before the FIFO emptied")
+	}
+	var got []byte
+	for range 2 {
+		got = append(got, k.Read(KEYBOARD_BASE+KBD_DATA))
+		k.Tick(1)
+	}
+	if string(got) != "hi" || interrupts != 2 {
+		t.Errorf("read %q with %d interrupts, want \"hi\" with 2", got, interrupts)
+	}
+}
@@ -0,0 +1,491 @@
+package nandpu
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Peripherals live in an I/O page overlaid on the top of the ROM.
+const (
+	IO_BASE       uint16 = 0x7F00
+	TIMER_BASE    uint16 = IO_BASE + 0x00
+	KEYBOARD_BASE uint16 = IO_BASE + 0x10
+	DISK_BASE     uint16 = IO_BASE + 0x20
+	BANK_BASE     uint16 = IO_BASE + 0x30
+	UART_BASE     uint16 = IO_BASE + 0x40
+	DMA_BASE      uint16 = IO_BASE + 0x50
+	MATH_BASE     uint16 = IO_BASE + 0x60
+	SEMIHOST_BASE uint16 = IO_BASE + 0x70
+)
+
+// MachineOptions selects the optional peripherals attached to the standard
+// board from the command line.
+type MachineOptions struct {
+	EEPROM       bool // emulate in-circuit writes to the ROM
+	EEPROMSave   bool // write a modified EEPROM back to the ROM image
+	Timer        bool
+	Keyboard     bool
+	Disk         string // disk image for the block device; none is attached when empty
+	DiskReadOnly bool
+	UART         bool
+	DMA          bool
+	Math         bool
+	MathLatency  uint64 // cycles per math operation; 0 uses the defaults
+	Semihost     bool
+	SemihostDir  string // sandbox for semihosted file access; file commands fail when empty
+	NVRAM        []NVRAMOptions
+	ROMBanks     bool // bank the ROM image into the 0x4000-0x7FFF window
+	RAMBanks     int  // number of RAM banks in the 0x8000-0xBFFF window; 0 disables banking
+	OpenBus      byte
+	Unmapped     string // ignore, warn or fault on unmapped accesses
+	UninitRead   string // ignore, warn or fault on reads of RAM not written since reset
+	RAMFill      string // zero or random RAM contents at power-on and reset
+	RAMSeed      uint64 // seed for random RAM contents
+	Stack        StackConfig
+	Protection   string // ignore, warn (the default) or fault on accesses the permissions don't allow
+	Protect      []ProtectConfig
+}
+
+// NVRAMOptions marks a range of RAM as battery-backed by a host file.
+type NVRAMOptions struct {
+	Start, End uint16
+	Path       string
+}
+
+// ParseNVRAMOptions parses a "start-end=path" range with hex addresses,
+// e.g. "8000-80FF=save.bin".
+func ParseNVRAMOptions(spec string) (NVRAMOptions, error) {
+	var nv NVRAMOptions
+	rng, path, ok := strings.Cut(spec, "=")
+	if !ok || path == "" {
+		return nv, fmt.Errorf("missing file in NVRAM range %q", spec)
+	}
+	if _, err := fmt.Sscanf(rng, "%x-%x", &nv.Start, &nv.End); err != nil {
+		return nv, fmt.Errorf("invalid NVRAM range %q: %v", rng, err)
+	}
+	if nv.Start < 0x8000 || nv.End < nv.Start {
+		return nv, fmt.Errorf("NVRAM range %q must lie within RAM at 0x8000-0xFFFF", rng)
+	}
+	nv.Path = path
+	return nv, nil
+}
+
+// Machine is a NANDPU together with the peripherals attached to it.
+// Peripherals that were not configured are nil.
+type Machine struct {
+	CPU      *NANDPU
+	ClockHz  uint64
+	EEPROM   *EEPROM
+	Timer    *Timer
+	Keyboard *Keyboard
+	Disk     *BlockDevice
+	UARTs    []*UART
+	DMA      *DMA
+	Math     *MathUnit
+	Semihost *Semihost
+	Devices  []AttachedDevice
+	NVRAM    []*RAM
+	ROMBank  *BankedMemory
+	RAMBank  *BankedMemory
+
+	named    map[string]MemoryRegionEntry
+	protects []ProtectConfig
+}
+
+// NewMachine builds a NANDPU and its memory map from a board description.
+func NewMachine(cfg *MachineConfig) (*Machine, error) {
+	c := NewNANDPU()
+	c.ResetPC = cfg.ResetPC
+	c.ResetSP = cfg.InitialSP
+	c.PC.val, c.curPC = c.ResetPC, c.ResetPC
+	c.SP.val = c.ResetSP
+
+	c.Mem.OpenBus = cfg.OpenBus
+	switch cfg.Unmapped {
+	case "", "ignore":
+	case "warn":
+		c.Mem.OnUnmapped = func(addr uint16, write bool) {
+			Logger.Printf("WARNING: unmapped %s at 0x%04X (PC=%s)", accessName(write), addr, c.where(c.curPC))
+		}
+	case "fault":
+		c.Mem.OnUnmapped = func(addr uint16, write bool) {
+			c.RaiseFault(fmt.Sprintf("unmapped %s at 0x%04X", accessName(write), addr))
+		}
+	default:
+		return nil, fmt.Errorf("unknown unmapped access policy %q", cfg.Unmapped)
+	}
+	switch cfg.UninitRead {
+	case "", "ignore":
+	case "warn":
+		c.OnUninitRead = func(addr uint16) {
+			Logger.Printf("WARNING: read of uninitialised RAM at %s (PC=%s)", c.where(addr), c.where(c.curPC))
+		}
+	case "fault":
+		c.OnUninitRead = func(addr uint16) {
+			c.RaiseFault(fmt.Sprintf("read of uninitialised RAM at %s by the instruction at %s", c.where(addr), c.where(c.curPC)))
+		}
+	default:
+		return nil, fmt.Errorf("unknown uninitialised read policy %q", cfg.UninitRead)
+	}
+	if s := cfg.Stack; s != nil {
+		c.Stack = &StackLimits{Top: cmp.Or(s.Top, cfg.InitialSP), Limit: s.Limit, Guard: s.Guard, DataEnd: s.DataEnd}
+	}
+	switch cfg.RAMFill {
+	case "", "zero":
+	case "random":
+		Logger.Printf("Filling RAM with random bytes from seed %d", cfg.RAMSeed)
+	default:
+		return nil, fmt.Errorf("unknown RAM fill %q", cfg.RAMFill)
+	}
+
+	m := &Machine{CPU: c, ClockHz: cfg.ClockHz, named: map[string]MemoryRegionEntry{}, protects: cfg.Protect}
+	switch cfg.Protection {
+	case "ignore":
+	case "", "warn":
+		c.OnProtection = func(addr uint16, access Perm) {
+			Logger.Printf("WARNING: %s", m.violation(addr, access))
+		}
+	case "fault":
+		c.OnProtection = func(addr uint16, access Perm) {
+			c.RaiseFault(m.violation(addr, access))
+		}
+	default:
+		return nil, fmt.Errorf("unknown protection policy %q", cfg.Protection)
+	}
+
+	for _, r := range cfg.Regions {
+		if err := m.addRegion(cfg, r); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("%s region at 0x%04X: %w", r.Type, r.Base, err)
+		}
+	}
+	for _, p := range cfg.Protect {
+		perm, err := ParsePerm(p.Perms)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		if c.OnProtection != nil {
+			c.Mem.Protect(p.Start, p.End, perm)
+		}
+	}
+	if m.ROMBank != nil || m.RAMBank != nil {
+		c.Resolve = m.Resolve
+	}
+	return m, nil
+}
+
+// violation describes an access the memory protection doesn't allow.
+func (m *Machine) violation(addr uint16, access Perm) string {
+	c := m.CPU
+	where := c.where(addr).String()
+	if name := m.regionName(addr); name != "" {
+		where += " in " + name
+	}
+	msg := fmt.Sprintf("%s of %s (%s)", permAccess(access), where, c.Mem.Perm(addr))
+	if access == PERM_X {
+		if addr != c.curPC {
+			// An operand of the instruction at curPC.
+			return msg + fmt.Sprintf(" by the instruction at %s", c.where(c.curPC))
+		}
+		return msg
+	}
+	return msg + fmt.Sprintf(" by %s at %s", OpcodeNames[c.INST.val], c.where(c.curPC))
+}
+
+// regionName names the protected range or region at addr, if it has a name.
+func (m *Machine) regionName(addr uint16) string {
+	for i := len(m.protects) - 1; i >= 0; i-- {
+		if p := m.protects[i]; p.Name != "" && p.Start <= addr && addr <= p.End {
+			return p.Name
+		}
+	}
+	name, priority := "", -1
+	for n, e := range m.named {
+		if e.start <= addr && addr <= e.end && e.priority > priority {
+			name, priority = n, e.priority
+		}
+	}
+	return name
+}
+
+func accessName(write bool) string {
+	if write {
+		return "write"
+	}
+	return "read"
+}
+
+func (m *Machine) addRegion(cfg *MachineConfig, r RegionConfig) error {
+	c := m.CPU
+	size := r.Size
+	if size < 0 || size > 0xFFFF || int(r.Base)+size > 0x10000 {
+		return fmt.Errorf("size 0x%X does not fit in the address space", size)
+	}
+	if _, device := deviceTypes[r.Type]; !device && r.Type != "bank_select" && size == 0 {
+		return fmt.Errorf("missing size")
+	}
+
+	var region MemoryRegion
+	switch r.Type {
+	case "rom":
+		_, data, err := cfg.loadImage(r)
+		if err != nil {
+			return err
+		}
+		rom := NewROM(r.Base, uint16(size))
+		rom.Init(data)
+		region = rom
+
+	case "ram":
+		ram := NewRAM(r.Base, uint16(size))
+		if c.OnUninitRead != nil {
+			ram.TrackWrites()
+		}
+		if cfg.RAMFill == "random" {
+			ram.FillRandom(cfg.RAMSeed)
+		}
+		region = ram
+
+	case "nvram":
+		if r.Image == "" {
+			return fmt.Errorf("nvram needs an image to persist to")
+		}
+		ram, err := NewNVRAM(r.Base, uint16(size), cfg.imagePath(r))
+		if err != nil {
+			return err
+		}
+		m.NVRAM = append(m.NVRAM, ram)
+		region = ram
+
+	case "eeprom":
+		if m.EEPROM != nil {
+			return fmt.Errorf("only one eeprom is supported")
+		}
+		path, data, err := cfg.loadImage(r)
+		if err != nil {
+			return err
+		}
+		if !r.Save {
+			path = ""
+		}
+		m.EEPROM = NewEEPROM(r.Base, uint16(size), data, path, cfg.ClockHz)
+		region = m.EEPROM
+
+	case "banked_rom":
+		_, data, err := cfg.loadImage(r)
+		if err != nil {
+			return err
+		}
+		bank := NewBankedROM(r.Base, size, data, r.InitialBank)
+		if m.ROMBank == nil {
+			m.ROMBank = bank
+		}
+		region = bank
+
+	case "banked_ram":
+		if r.Banks < 1 {
+			return fmt.Errorf("banked_ram needs at least one bank")
+		}
+		bank := NewBankedRAM(r.Base, size, r.Banks)
+		if c.OnUninitRead != nil {
+			bank.TrackWrites()
+		}
+		if m.RAMBank == nil {
+			m.RAMBank = bank
+		}
+		region = bank
+
+	case "bank_select":
+		var windows []*BankedMemory
+		for _, name := range r.Windows {
+			if name == "" {
+				windows = append(windows, nil)
+				continue
+			}
+			bank, ok := m.named[name].region.(*BankedMemory)
+			if !ok {
+				return fmt.Errorf("%q is not a banked region", name)
+			}
+			windows = append(windows, bank)
+		}
+		size = len(windows)
+		region = NewBankSelect(r.Base, windows...)
+
+	case "mirror":
+		target, ok := m.named[r.Target]
+		if !ok {
+			return fmt.Errorf("unknown mirror target %q", r.Target)
+		}
+		var mask uint16
+		if r.Mask != nil {
+			mask = *r.Mask
+		} else {
+			mask = target.end - target.start
+			if mask&(mask+1) != 0 {
+				return fmt.Errorf("target %q is not a power of two in size, so a mask must be given", r.Target)
+			}
+		}
+		if int(target.start)+int(mask) > int(target.end) {
+			return fmt.Errorf("mask 0x%04X runs past the end of %q", mask, r.Target)
+		}
+		region = NewMirror(r.Base, target.region, target.start, mask)
+
+	default:
+		dt, ok := deviceTypes[r.Type]
+		if !ok {
+			return fmt.Errorf("unknown region type")
+		}
+		if size == 0 {
+			size = int(dt.size)
+		}
+		r.Name = m.deviceName(r)
+		dev, err := dt.factory(m, cfg, r)
+		if err != nil {
+			return err
+		}
+		if irq, ok := dev.(InterruptSource); ok {
+			name := r.Name
+			irq.SetInterruptHandler(func() { Logger.Printf("%s interrupt at cycle %d", name, c.Cycles) })
+		}
+		m.Devices = append(m.Devices, AttachedDevice{r.Name, dev})
+		region = dev
+	}
+
+	if size == 0 || int(r.Base)+size > 0x10000 {
+		return fmt.Errorf("size 0x%X does not fit in the address space", size)
+	}
+	end := r.Base + uint16(size-1)
+	perm, err := r.perm()
+	if err != nil {
+		return err
+	}
+	if err := c.Attach(r.Base, end, region, r.priority()); err != nil {
+		return err
+	}
+	if c.OnProtection != nil {
+		// Where regions overlap, the winner's permissions apply.
+		for addr := int(r.Base); addr <= int(end); addr++ {
+			if c.Mem.region(uint16(addr)) == region {
+				c.Mem.Protect(uint16(addr), uint16(addr), perm)
+			}
+		}
+	}
+	if r.Name != "" {
+		m.named[r.Name] = MemoryRegionEntry{r.Base, end, region, r.priority()}
+	}
+	return nil
+}
+
+// AttachedDevice is a device in a machine under the name it can be found by.
+type AttachedDevice struct {
+	Name string
+	Device
+}
+
+// deviceName names a device after its region, or its type when the region is
+// unnamed, numbering repeats.
+func (m *Machine) deviceName(r RegionConfig) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	name := r.Type
+	for n := 1; m.Device(name) != nil; n++ {
+		name = fmt.Sprintf("%s%d", r.Type, n)
+	}
+	return name
+}
+
+// Device finds an attached device by name.
+func (m *Machine) Device(name string) Device {
+	for _, d := range m.Devices {
+		if d.Name == name {
+			return d.Device
+		}
+	}
+	return nil
+}
+
+// SnapshotDevices saves the state of every device that supports it, by name.
+func (m *Machine) SnapshotDevices() (map[string][]byte, error) {
+	snap := map[string][]byte{}
+	for _, d := range m.Devices {
+		s, ok := d.Device.(Snapshotter)
+		if !ok {
+			continue
+		}
+		data, err := s.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.Name, err)
+		}
+		snap[d.Name] = data
+	}
+	return snap, nil
+}
+
+// RestoreDevices restores device state saved by SnapshotDevices.
+func (m *Machine) RestoreDevices(snap map[string][]byte) error {
+	for name, data := range snap {
+		s, ok := m.Device(name).(Snapshotter)
+		if !ok {
+			return fmt.Errorf("no device %q to restore", name)
+		}
+		if err := s.Restore(data); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Resolve qualifies an address with the bank currently mapped at it, for
+// anything that needs to tell code or data in different banks apart.
+func (m *Machine) Resolve(addr uint16) BankedAddr {
+	if m.ROMBank != nil && m.ROMBank.Contains(addr) {
+		return BankedAddr{Bank: m.ROMBank.Bank(), Addr: addr}
+	}
+	if m.RAMBank != nil && m.RAMBank.Contains(addr) {
+		return BankedAddr{Bank: m.RAMBank.Bank(), Addr: addr}
+	}
+	return BankedAddr{Bank: -1, Addr: addr}
+}
+
+// Close flushes persistent state and releases any host files held by the
+// machine's peripherals.
+func (m *Machine) Close() {
+	m.SaveNVRAM()
+	if m.EEPROM != nil {
+		m.EEPROM.WearReport()
+		if err := m.EEPROM.Save(); err != nil {
+			Logger.Printf("Failed to save EEPROM image: %v", err)
+		}
+	}
+	if m.DMA != nil && m.DMA.Bytes > 0 {
+		Logger.Printf("DMA moved %d bytes using %d stolen cycles (%.1f%% of %d)", m.DMA.Bytes, m.DMA.Stolen, 100*float64(m.DMA.Stolen)/float64(max(m.CPU.Cycles, 1)), m.CPU.Cycles)
+	}
+	if m.Math != nil && len(m.Math.Ops) > 0 {
+		Logger.Printf("Math unit: %d MUL8, %d MUL16, %d DIV", m.Math.Ops[MATH_MUL8], m.Math.Ops[MATH_MUL16], m.Math.Ops[MATH_DIV])
+	}
+	if s := m.CPU.Stack; s != nil && s.Limit > 0 {
+		Logger.Printf("Stack reached %d of %d bytes deep", m.CPU.MaxStackDepth, s.Top-s.Limit+1)
+	} else if m.CPU.MaxStackDepth > 0 {
+		Logger.Printf("Stack reached %d bytes deep", m.CPU.MaxStackDepth)
+	}
+	for _, d := range m.Devices {
+		if closer, ok := d.Device.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				Logger.Printf("Failed to close %s: %v", d.Name, err)
+			}
+		}
+	}
+}
+
+// SaveNVRAM writes all battery-backed RAM to its backing files.
+func (m *Machine) SaveNVRAM() {
+	for _, ram := range m.NVRAM {
+		if err := ram.Save(); err != nil {
+			Logger.Printf("Failed to save NVRAM to %s: %v", ram.path, err)
+		}
+	}
+}
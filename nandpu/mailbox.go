@@ -0,0 +1,79 @@
+package nandpu
+
+// Mailbox register offsets from the device's base address.
+const (
+	MBOX_SEM_0  uint16 = 0x0 // semaphores: reading returns the old value and sets it to 1; write 0 to release
+	MBOX_SEM_1  uint16 = 0x1
+	MBOX_SEM_2  uint16 = 0x2
+	MBOX_SEM_3  uint16 = 0x3
+	MBOX_STATUS uint16 = 0x4 // bit n set while mailbox n holds a byte
+	MBOX_DATA_0 uint16 = 0x8 // mailboxes: writing fills the slot, reading empties it
+	MBOX_DATA_1 uint16 = 0x9
+	MBOX_DATA_2 uint16 = 0xA
+	MBOX_DATA_3 uint16 = 0xB
+
+	MBOX_SIZE uint16 = 0x10
+
+	mboxCount = 4
+)
+
+// Mailbox provides test-and-set semaphores and single-byte mailboxes for
+// CPUs sharing a bus. Accesses are atomic because the scheduler only ever
+// runs one CPU at a time.
+type Mailbox struct {
+	base uint16
+	sem  [mboxCount]byte
+	data [mboxCount]byte
+	full byte
+}
+
+func NewMailbox(base uint16) *Mailbox { return &Mailbox{base: base} }
+
+func (m *Mailbox) Read(addr uint16) byte {
+	offset := addr - m.base
+	val := m.Peek(addr)
+	switch {
+	case offset < MBOX_SEM_0+mboxCount:
+		m.sem[offset] = 1
+	case offset >= MBOX_DATA_0 && offset < MBOX_DATA_0+mboxCount:
+		m.full &^= 1 << (offset - MBOX_DATA_0)
+	}
+	return val
+}
+
+func (m *Mailbox) Peek(addr uint16) byte {
+	offset := addr - m.base
+	switch {
+	case offset < MBOX_SEM_0+mboxCount:
+		return m.sem[offset]
+	case offset == MBOX_STATUS:
+		return m.full
+	case offset >= MBOX_DATA_0 && offset < MBOX_DATA_0+mboxCount:
+		return m.data[offset-MBOX_DATA_0]
+	}
+	return 0x00
+}
+
+func (m *Mailbox) Write(addr uint16, val byte) {
+	offset := addr - m.base
+	switch {
+	case offset < MBOX_SEM_0+mboxCount:
+		m.sem[offset] = val & 0x01
+	case offset >= MBOX_DATA_0 && offset < MBOX_DATA_0+mboxCount:
+		m.data[offset-MBOX_DATA_0] = val
+		m.full |= 1 << (offset - MBOX_DATA_0)
+	}
+}
+
+// Poke sets semaphores and mailbox contents without changing the full flags.
+func (m *Mailbox) Poke(addr uint16, val byte) {
+	offset := addr - m.base
+	switch {
+	case offset < MBOX_SEM_0+mboxCount:
+		m.sem[offset] = val & 0x01
+	case offset == MBOX_STATUS:
+		m.full = val & (1<<mboxCount - 1)
+	case offset >= MBOX_DATA_0 && offset < MBOX_DATA_0+mboxCount:
+		m.data[offset-MBOX_DATA_0] = val
+	}
+}
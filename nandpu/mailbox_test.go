@@ -0,0 +1,65 @@
+package nandpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMailboxHandoffBetweenCPUs(t *testing.T) {
+	const mbox = 0x7E00 // page aligned, so the low byte of a register address is its offset
+	sender := []byte{
+		OP_LDI, 0x2A, REG_A,
+		OP_STOI, REG_A, byte(MBOX_DATA_0), mbox >> 8,
+		OP_SPECIAL_HALT,
+	}
+	// The receiver starts polling before the sender has written anything.
+	receiver := []byte{
+		OP_LDMI, byte(MBOX_STATUS), mbox >> 8, REG_B, // 0x00: wait for mailbox 0 to fill
+		OP_CMP,
+		OP_BZSI, 0x00, 0x00,
+		OP_LDMI, byte(MBOX_DATA_0), mbox >> 8, REG_A,
+		OP_SPECIAL_HALT,
+	}
+	dir := t.TempDir()
+	var cpus []CPUConfig
+	for i, rom := range [][]byte{receiver, sender} {
+		path := filepath.Join(dir, fmt.Sprintf("cpu%d.bin", i))
+		if err := os.WriteFile(path, rom, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cpus = append(cpus, CPUConfig{ROM: path})
+	}
+	s, err := NewSystem(&SystemConfig{CPUs: cpus, Shared: []RegionConfig{{Type: "mailbox", Base: mbox}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, m := range s.Machines {
+		m.CPU.Trace = false
+	}
+
+	for rounds := 0; s.Step(); rounds++ {
+		if rounds > 100 {
+			t.Fatal("CPUs still running after 100 rounds")
+		}
+	}
+	recv := s.Machines[0].CPU
+	if recv.Fault != nil || recv.RegA.Get() != 0x2A {
+		t.Errorf("receiver got 0x%02X (fault %v), want 0x2A", recv.RegA.Get(), recv.Fault)
+	}
+	if full := recv.Mem.Peek(mbox + MBOX_STATUS); full != 0 {
+		t.Errorf("mailbox status 0x%02X after the receiver read it, want 0x00", full)
+	}
+
+	// Test-and-set: only the first CPU to read a free semaphore gets it.
+	a, b := s.Machines[0].CPU, s.Machines[1].CPU
+	if a.Mem.Read(mbox+MBOX_SEM_0) != 0 || b.Mem.Read(mbox+MBOX_SEM_0) != 1 {
+		t.Error("both CPUs acquired semaphore 0")
+	}
+	a.Mem.Write(mbox+MBOX_SEM_0, 0)
+	if b.Mem.Read(mbox+MBOX_SEM_0) != 0 {
+		t.Error("released semaphore wasn't free")
+	}
+}
@@ -0,0 +1,190 @@
+package nandpu
+
+import "fmt"
+
+// Math unit register offsets from the device's base address.
+const (
+	MATH_A_LO   uint16 = 0x0
+	MATH_A_HI   uint16 = 0x1
+	MATH_B_LO   uint16 = 0x2
+	MATH_B_HI   uint16 = 0x3
+	MATH_OP     uint16 = 0x4 // write an operation to start it; ignored while busy
+	MATH_STATUS uint16 = 0x5 // bit 0 busy, bit 1 divide by zero
+	MATH_R0     uint16 = 0x8 // result, little endian; the remainder of a divide is in R2
+	MATH_R1     uint16 = 0x9
+	MATH_R2     uint16 = 0xA
+	MATH_R3     uint16 = 0xB
+
+	MATH_SIZE uint16 = 0x10
+
+	MATH_MUL8  byte = 0x01 // A_LO * B_LO -> R0-R1
+	MATH_MUL16 byte = 0x02 // A * B -> R0-R3
+	MATH_DIV   byte = 0x03 // A / B_LO -> R0-R1, remainder R2
+)
+
+const (
+	mathStatusBusy byte = 1 << iota
+	mathStatusDivZero
+)
+
+// Default cycles taken by each operation.
+var mathLatency = map[byte]uint64{
+	MATH_MUL8:  8,
+	MATH_MUL16: 16,
+	MATH_DIV:   16,
+}
+
+// MathUnit is a multiply/divide coprocessor. An operation takes a number of
+// cycles to complete, during which the busy flag is set and the result
+// registers still hold the previous result.
+type MathUnit struct {
+	base uint16
+
+	// Latency overrides the default cycles of every operation when non-zero.
+	Latency uint64
+
+	a, b    uint16
+	result  uint32
+	pending uint32
+	status  byte
+	busy    uint64
+
+	Ops map[byte]uint64 // operations started since power on, by opcode
+}
+
+func init() {
+	RegisterDevice("math", MATH_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.Math != nil {
+			return nil, fmt.Errorf("only one math unit is supported")
+		}
+		m.Math = NewMathUnit(r.Base, r.Latency)
+		return m.Math, nil
+	})
+}
+
+func NewMathUnit(base uint16, latency uint64) *MathUnit {
+	return &MathUnit{base: base, Latency: latency, Ops: map[byte]uint64{}}
+}
+
+func (u *MathUnit) Read(addr uint16) byte { return u.Peek(addr) }
+
+func (u *MathUnit) Peek(addr uint16) byte {
+	switch addr - u.base {
+	case MATH_A_LO:
+		return byte(u.a)
+	case MATH_A_HI:
+		return byte(u.a >> 8)
+	case MATH_B_LO:
+		return byte(u.b)
+	case MATH_B_HI:
+		return byte(u.b >> 8)
+	case MATH_STATUS:
+		return u.status
+	case MATH_R0:
+		return byte(u.result)
+	case MATH_R1:
+		return byte(u.result >> 8)
+	case MATH_R2:
+		return byte(u.result >> 16)
+	case MATH_R3:
+		return byte(u.result >> 24)
+	}
+	return 0x00
+}
+
+func (u *MathUnit) Write(addr uint16, val byte) {
+	switch addr - u.base {
+	case MATH_A_LO:
+		u.a = (u.a & 0xFF00) | uint16(val)
+	case MATH_A_HI:
+		u.a = (u.a & 0x00FF) | (uint16(val) << 8)
+	case MATH_B_LO:
+		u.b = (u.b & 0xFF00) | uint16(val)
+	case MATH_B_HI:
+		u.b = (u.b & 0x00FF) | (uint16(val) << 8)
+	case MATH_OP:
+		if u.status&mathStatusBusy == 0 {
+			u.start(val)
+		}
+	}
+}
+
+// Poke sets operands and results without starting an operation.
+func (u *MathUnit) Poke(addr uint16, val byte) {
+	switch addr - u.base {
+	case MATH_OP:
+	case MATH_STATUS:
+		u.status = val
+	case MATH_R0, MATH_R1, MATH_R2, MATH_R3:
+		shift := 8 * (addr - u.base - MATH_R0)
+		u.result = (u.result &^ (0xFF << shift)) | (uint32(val) << shift)
+	default:
+		u.Write(addr, val)
+	}
+}
+
+func (u *MathUnit) start(op byte) {
+	latency, ok := mathLatency[op]
+	if !ok {
+		return
+	}
+	if u.Latency != 0 {
+		latency = u.Latency
+	}
+
+	u.status = 0
+	switch op {
+	case MATH_MUL8:
+		u.pending = uint32(byte(u.a)) * uint32(byte(u.b))
+	case MATH_MUL16:
+		u.pending = uint32(u.a) * uint32(u.b)
+	case MATH_DIV:
+		divisor := uint16(byte(u.b))
+		if divisor == 0 {
+			u.pending = 0xFFFFFFFF
+			u.status |= mathStatusDivZero
+		} else {
+			u.pending = uint32(u.a/divisor) | uint32(u.a%divisor)<<16
+		}
+	}
+	u.Ops[op]++
+	u.busy = latency
+	u.status |= mathStatusBusy
+}
+
+func (u *MathUnit) Tick(cycles uint64) {
+	if u.status&mathStatusBusy == 0 {
+		return
+	}
+	if u.busy > cycles {
+		u.busy -= cycles
+		return
+	}
+	u.busy = 0
+	u.result = u.pending
+	u.status &^= mathStatusBusy
+}
+
+func (u *MathUnit) Reset() {
+	*u = MathUnit{base: u.base, Latency: u.Latency, Ops: u.Ops}
+}
+
+type mathState struct {
+	A, B            uint16
+	Result, Pending uint32
+	Status          byte
+	Busy            uint64
+}
+
+func (u *MathUnit) Snapshot() ([]byte, error) {
+	return encodeState(mathState{u.a, u.b, u.result, u.pending, u.status, u.busy})
+}
+
+func (u *MathUnit) Restore(data []byte) error {
+	var s mathState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	u.a, u.b, u.result, u.pending, u.status, u.busy = s.A, s.B, s.Result, s.Pending, s.Status, s.Busy
+	return nil
+}
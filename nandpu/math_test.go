@@ -0,0 +1,73 @@
+package nandpu
+
+import "testing"
+
+// mathRun starts op on a and b and waits for it, returning the result
+// registers and the cycles taken.
+func mathRun(u *MathUnit, op byte, a, b uint16) (uint32, uint64) {
+	u.Write(MATH_BASE+MATH_A_LO, byte(a))
+	u.Write(MATH_BASE+MATH_A_HI, byte(a>>8))
+	u.Write(MATH_BASE+MATH_B_LO, byte(b))
+	u.Write(MATH_BASE+MATH_B_HI, byte(b>>8))
+	u.Write(MATH_BASE+MATH_OP, op)
+	return mathWait(u)
+}
+
+// mathWait ticks the unit until the operation in progress is done.
+func mathWait(u *MathUnit) (uint32, uint64) {
+	var cycles uint64
+	for ; u.Peek(MATH_BASE+MATH_STATUS)&mathStatusBusy != 0; cycles++ {
+		u.Tick(1)
+	}
+	var result uint32
+	for i := range uint16(4) {
+		result |= uint32(u.Peek(MATH_BASE+MATH_R0+i)) << (8 * i)
+	}
+	return result, cycles
+}
+
+func TestMathOperations(t *testing.T) {
+	u := NewMathUnit(MATH_BASE, 0)
+	for _, tc := range []struct {
+		name   string
+		op     byte
+		a, b   uint16
+		result uint32
+	}{
+		{"MUL8", MATH_MUL8, 0x12FF, 0x34FF, 0xFE01},
+		{"MUL16", MATH_MUL16, 0xFFFF, 0xFFFF, 0xFFFE0001},
+		{"DIV", MATH_DIV, 1000, 0x0107, 142 | 6<<16},
+	} {
+		result, cycles := mathRun(u, tc.op, tc.a, tc.b)
+		if result != tc.result || cycles != mathLatency[tc.op] {
+			t.Errorf("%s = %08X in %d cycles, want %08X in %d", tc.name, result, cycles, tc.result, mathLatency[tc.op])
+		}
+	}
+}
+
+func TestMathDivideByZero(t *testing.T) {
+	u := NewMathUnit(MATH_BASE, 4)
+	mathRun(u, MATH_MUL8, 3, 5)
+
+	// Only B_LO is the divisor, so a non-zero high byte doesn't help.
+	u.Write(MATH_BASE+MATH_B_LO, 0)
+	u.Write(MATH_BASE+MATH_B_HI, 1)
+	u.Write(MATH_BASE+MATH_OP, MATH_DIV)
+	if u.Peek(MATH_BASE+MATH_R0) != 15 {
+		t.Error("result changed before the divide finished")
+	}
+	result, cycles := mathWait(u)
+	if cycles != 4 {
+		t.Errorf("divide took %d cycles, want the 4 set as latency", cycles)
+	}
+	if status := u.Peek(MATH_BASE + MATH_STATUS); status != mathStatusDivZero || result != 0xFFFFFFFF {
+		t.Errorf("divide by zero gave %08X with status %02X, want FFFFFFFF with %02X", result, status, mathStatusDivZero)
+	}
+
+	if result, _ := mathRun(u, MATH_DIV, 10, 3); result != 3|1<<16 || u.Peek(MATH_BASE+MATH_STATUS) != 0 {
+		t.Errorf("next divide gave %08X with status %02X, want 00010003 with the flag cleared", result, u.Peek(MATH_BASE+MATH_STATUS))
+	}
+	if u.Ops[MATH_DIV] != 2 {
+		t.Errorf("%d divides counted, want 2", u.Ops[MATH_DIV])
+	}
+}
@@ -0,0 +1,314 @@
+package nandpu
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"slices"
+)
+
+// MemoryRegion is anything that can be mapped onto the bus. Read and Write
+// are real bus cycles. Peek and Poke are for debugging: they must not fire
+// OnRead/OnWrite hooks, pop FIFOs, clear status bits or start commands.
+type MemoryRegion interface {
+	Read(addr uint16) byte
+	Write(addr uint16, value byte)
+	Peek(addr uint16) byte
+	Poke(addr uint16, value byte)
+}
+
+type RAM struct {
+	data     []byte
+	base     uint16
+	path     string     // backing file for battery-backed RAM
+	written  []byte     // bitmap of bytes written since reset, when tracked
+	rng      *rand.Rand // refills the contents at reset, when set
+	watchers []func(addr uint16)
+	OnRead   func(addr uint16)
+	OnWrite  func(addr uint16, value byte)
+}
+
+func NewRAM(base, size uint16) *RAM { return &RAM{data: make([]byte, size), base: base} }
+
+// NewNVRAM creates battery-backed RAM whose contents are loaded from path, if
+// it exists, and written back there by Save.
+func NewNVRAM(base, size uint16, path string) (*RAM, error) {
+	r := &RAM{data: make([]byte, size), base: base, path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	copy(r.data, data)
+	return r, nil
+}
+
+// Save writes battery-backed RAM to its backing file. It does nothing for
+// volatile RAM.
+func (r *RAM) Save() error {
+	if r.path == "" {
+		return nil
+	}
+	return os.WriteFile(r.path, r.data, 0644)
+}
+
+func (r *RAM) Read(addr uint16) byte {
+	if r.OnRead != nil {
+		r.OnRead(addr)
+	}
+	return r.data[addr-r.base]
+}
+func (r *RAM) Write(addr uint16, val byte) {
+	if r.OnWrite != nil {
+		r.OnWrite(addr, val)
+	}
+	r.Poke(addr, val)
+}
+func (r *RAM) Peek(addr uint16) byte { return r.data[addr-r.base] }
+func (r *RAM) Poke(addr uint16, val byte) {
+	off := addr - r.base
+	r.data[off] = val
+	if r.written != nil {
+		r.written[off/8] |= 1 << (off % 8)
+	}
+	for _, fn := range r.watchers {
+		fn(addr)
+	}
+}
+
+// TrackWrites starts recording which bytes have been written since reset.
+// RAM already being tracked keeps its record.
+func (r *RAM) TrackWrites() {
+	if r.written == nil {
+		r.written = make([]byte, (len(r.data)+7)/8)
+	}
+}
+
+// Written reports whether addr has been written since reset. Untracked RAM
+// counts as written.
+func (r *RAM) Written(addr uint16) bool {
+	off := addr - r.base
+	return r.written == nil || r.written[off/8]&(1<<(off%8)) != 0
+}
+
+// FillRandom fills the RAM with bytes generated from seed, like SRAM
+// powering up, and fills it again at every reset.
+func (r *RAM) FillRandom(seed uint64) {
+	r.rng = rand.New(rand.NewPCG(seed, uint64(r.base)))
+	r.fill()
+}
+
+func (r *RAM) fill() {
+	for i := range r.data {
+		r.data[i] = byte(r.rng.Uint32())
+		for _, fn := range r.watchers {
+			fn(r.base + uint16(i))
+		}
+	}
+}
+
+func (r *RAM) Reset() {
+	clear(r.written)
+	if r.rng != nil {
+		r.fill()
+	}
+}
+func (r *RAM) watch(fn func(addr uint16)) { r.watchers = append(r.watchers, fn) }
+
+// writeTracker is implemented by memory that can tell which bytes have been
+// written since reset.
+type writeTracker interface {
+	Written(addr uint16) bool
+}
+
+type ROM struct {
+	data     []byte
+	base     uint16
+	watchers []func(addr uint16)
+	OnRead   func(addr uint16)
+	OnWrite  func(adds uint16, value byte)
+}
+
+func NewROM(base, size uint16) *ROM { return &ROM{data: make([]byte, size), base: base} }
+func (r *ROM) Init(data []byte)     { copy(r.data, data) }
+func (r *ROM) Read(addr uint16) byte {
+	if r.OnRead != nil {
+		r.OnRead(addr)
+	}
+	return r.data[addr-r.base]
+}
+func (r *ROM) Write(addr uint16, val byte) {
+	if r.OnWrite != nil {
+		r.OnWrite(addr, val)
+	}
+}
+
+func (r *ROM) Peek(addr uint16) byte { return r.data[addr-r.base] }
+
+// Poke patches the ROM contents, which the bus can't do.
+func (r *ROM) Poke(addr uint16, val byte) {
+	r.data[addr-r.base] = val
+	for _, fn := range r.watchers {
+		fn(addr)
+	}
+}
+func (r *ROM) watch(fn func(addr uint16)) { r.watchers = append(r.watchers, fn) }
+
+// Mirror makes a region visible at another range of addresses, as happens
+// when some address lines are left undecoded. The offset into the mirror is
+// masked before being added to the target's base address.
+type Mirror struct {
+	region     MemoryRegion
+	base       uint16
+	targetBase uint16
+	mask       uint16
+}
+
+func NewMirror(base uint16, region MemoryRegion, targetBase, mask uint16) *Mirror {
+	return &Mirror{region: region, base: base, targetBase: targetBase, mask: mask}
+}
+
+func (m *Mirror) target(addr uint16) uint16   { return m.targetBase + (addr-m.base)&m.mask }
+func (m *Mirror) Read(addr uint16) byte       { return m.region.Read(m.target(addr)) }
+func (m *Mirror) Write(addr uint16, val byte) { m.region.Write(m.target(addr), val) }
+func (m *Mirror) Peek(addr uint16) byte       { return m.region.Peek(m.target(addr)) }
+func (m *Mirror) Poke(addr uint16, val byte)  { m.region.Poke(m.target(addr), val) }
+
+// Written reports whether the mirrored byte has been written since reset.
+// Bytes of memory that isn't tracked count as written.
+func (m *Mirror) Written(addr uint16) bool {
+	t, ok := m.region.(writeTracker)
+	return !ok || t.Written(m.target(addr))
+}
+
+const PAGE_SIZE = 0x100
+
+// MemMap routes bus accesses to the mapped regions. Where regions overlap,
+// the one with the higher priority wins; regions of equal priority may not
+// overlap. Regions are also indexed by 256-byte page, so an access costs one
+// or two array lookups however many regions there are.
+type MemMap struct {
+	regions []MemoryRegionEntry
+	pages   [0x10000 / PAGE_SIZE]memPage
+
+	// OpenBus is the value read from unmapped addresses.
+	OpenBus byte
+	// OnUnmapped is called for every access that no region handles.
+	OnUnmapped func(addr uint16, write bool)
+	// OnWrite is called before every bus write, mapped or not.
+	OnWrite func(addr uint16, val byte)
+
+	perms *[0x10000]Perm // by address, once any have been set
+}
+
+// memPage holds the region covering a whole page, or per-byte regions for a
+// page split between regions. Unmapped addresses are nil.
+type memPage struct {
+	region MemoryRegion
+	split  *[PAGE_SIZE]MemoryRegion
+}
+
+type MemoryRegionEntry struct {
+	start, end uint16
+	region     MemoryRegion
+	priority   int
+}
+
+func (m *MemMap) AddRegion(start, end uint16, region MemoryRegion, priority int) error {
+	if end < start {
+		return fmt.Errorf("region end 0x%04X is below its start 0x%04X", end, start)
+	}
+	for _, entry := range m.regions {
+		if entry.priority == priority && start <= entry.end && entry.start <= end {
+			return fmt.Errorf("region 0x%04X-0x%04X overlaps 0x%04X-0x%04X at priority %d",
+				start, end, entry.start, entry.end, priority)
+		}
+	}
+
+	// Keep the regions ordered by priority so the first match wins.
+	i := 0
+	for i < len(m.regions) && m.regions[i].priority >= priority {
+		i++
+	}
+	m.regions = slices.Insert(m.regions, i, MemoryRegionEntry{start, end, region, priority})
+	for page := start / PAGE_SIZE; ; page++ {
+		m.indexPage(page)
+		if page == end/PAGE_SIZE {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemMap) indexPage(page uint16) {
+	var regions [PAGE_SIZE]MemoryRegion
+	uniform := true
+	for i := range regions {
+		if entry, ok := m.lookup(page*PAGE_SIZE + uint16(i)); ok {
+			regions[i] = entry.region
+		}
+		uniform = uniform && regions[i] == regions[0]
+	}
+	if uniform {
+		m.pages[page] = memPage{region: regions[0]}
+	} else {
+		m.pages[page] = memPage{split: &regions}
+	}
+}
+
+// region finds the region mapped at addr, or nil.
+func (m *MemMap) region(addr uint16) MemoryRegion {
+	page := &m.pages[addr/PAGE_SIZE]
+	if page.split != nil {
+		return page.split[addr%PAGE_SIZE]
+	}
+	return page.region
+}
+
+func (m *MemMap) lookup(addr uint16) (MemoryRegionEntry, bool) {
+	for _, entry := range m.regions {
+		if addr >= entry.start && addr <= entry.end {
+			return entry, true
+		}
+	}
+	return MemoryRegionEntry{}, false
+}
+
+func (m *MemMap) Read(addr uint16) byte {
+	if region := m.region(addr); region != nil {
+		return region.Read(addr)
+	}
+	if m.OnUnmapped != nil {
+		m.OnUnmapped(addr, false)
+	}
+	return m.OpenBus
+}
+
+func (m *MemMap) Write(addr uint16, val byte) {
+	if m.OnWrite != nil {
+		m.OnWrite(addr, val)
+	}
+	if region := m.region(addr); region != nil {
+		region.Write(addr, val)
+		return
+	}
+	if m.OnUnmapped != nil {
+		m.OnUnmapped(addr, true)
+	}
+}
+
+// Peek reads memory for debugging without side effects or unmapped reports.
+func (m *MemMap) Peek(addr uint16) byte {
+	if region := m.region(addr); region != nil {
+		return region.Peek(addr)
+	}
+	return m.OpenBus
+}
+
+// Poke writes memory for debugging without side effects or unmapped reports.
+func (m *MemMap) Poke(addr uint16, val byte) {
+	if region := m.region(addr); region != nil {
+		region.Poke(addr, val)
+	}
+}
@@ -0,0 +1,35 @@
+package nandpu
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUninitReadThroughBanksAndMirrors(t *testing.T) {
+	cfg := MachineOptions{UninitRead: "fault", RAMBanks: 2}.Config()
+	mask, priority := uint16(0x0FFF), 10
+	cfg.Regions = append(cfg.Regions, RegionConfig{Type: "mirror", Base: 0x6000, Size: 0x1000, Target: "ram", Mask: &mask, Priority: &priority})
+	c := newMachineConfig(t, nil, cfg).CPU
+	var reads []uint16
+	c.OnUninitRead = func(addr uint16) { reads = append(reads, addr) }
+
+	c.load(0x8000) // bank 0
+	c.store(0x8001, 1)
+	c.load(0x8001)
+	c.Mem.Write(BANK_BASE+BANK_SELECT_RAM, 1)
+	c.load(0x8001) // bank 1
+	c.load(0x6002) // mirror of the RAM hidden under the window
+	c.store(0x6003, 1)
+	c.load(0x6003)
+	if want := []uint16{0x8000, 0x8001, 0x6002}; !slices.Equal(reads, want) {
+		t.Errorf("uninitialised reads at %04X, want %04X", reads, want)
+	}
+
+	c.Reset()
+	reads = nil
+	c.load(0x8001)
+	c.load(0x6003)
+	if want := []uint16{0x8001, 0x6003}; !slices.Equal(reads, want) {
+		t.Errorf("uninitialised reads after reset at %04X, want %04X", reads, want)
+	}
+}
@@ -0,0 +1,858 @@
+// Package nandpu simulates the NANDPU and the boards built around it: the CPU,
+// its memory map, the devices that can be attached, and machines and systems
+// described by config files.
+package nandpu
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type NANDPU struct {
+	PC   Reg16 // Program Counter
+	INST Reg8  // Instruction Register
+	INC  Reg16 // Increment Register
+	SP   Reg16 // Stack Pointer
+
+	// Flags
+	Zero     bool
+	Carry    bool
+	Sign     bool
+	LessThan bool
+
+	// 8 Bit Registers
+	RegA Reg8
+	RegB Reg8
+	RegC Reg8
+	RegD Reg8
+
+	// 16 Bit Registers
+	RegM  *SplitReg16
+	RegXY *SplitReg16
+	RegJ  *SplitReg16
+
+	Reg8List  []Reg8Like
+	Reg16List []Reg16Like
+	registers map[string]any // by ISA name
+
+	Mem MemMap
+
+	// Register values loaded on reset.
+	ResetPC uint16
+	ResetSP uint16
+
+	// Cycles counts clock cycles since power-on. Devices are ticked from it,
+	// so anything timed against it stays deterministic between runs.
+	Cycles uint64
+	Fault  *Fault
+
+	// Trace logs every instruction and its effects. It is the slowest part of
+	// running a program, so turn it off for long runs.
+	Trace bool
+
+	// OnUninitRead is called when LDM, LDMI, POP or RET reads RAM that
+	// hasn't been written since reset.
+	OnUninitRead func(addr uint16)
+
+	// OnProtection is called for accesses the memory's permissions don't
+	// allow: data reads and writes by instructions, and instruction fetches.
+	OnProtection func(addr uint16, access Perm)
+
+	// Stack bounds the stack when set. MaxStackDepth is the most bytes it
+	// has held below ResetSP, or the top set in Stack.
+	Stack         *StackLimits
+	MaxStackDepth uint16
+
+	// ICache holds pre-decoded instructions when enabled, and cur is the
+	// one being executed. curPC is where the current instruction starts.
+	// Cached instructions skip their fetches, so the cache is bypassed while
+	// auditing or checking uninitialised reads, and only holds code whose
+	// every byte may be executed.
+	ICache *ICache
+	cur    *decodedInst
+	curPC  uint16
+
+	// Audit records every register and memory access when set.
+	Audit *Auditor
+
+	// SMC reports writes to executed code when set.
+	SMC *SMCReport
+
+	// Resolve gives the bank mapped at an address, so messages can tell
+	// code and data in different banks apart. Without it addresses are
+	// shown without banks.
+	Resolve func(addr uint16) BankedAddr
+
+	tickers   []Ticker
+	resetters []Resetter
+	halt      bool
+
+	// A reset during an instruction waits for it to finish.
+	executing    bool
+	resetPending bool
+
+	// hooked is set for an instruction when PC, INC, SP or INST has hooks,
+	// so the hot paths can update them directly otherwise.
+	hooked bool
+}
+
+// Fault describes a condition that stops the CPU, such as a watchdog timeout.
+type Fault struct {
+	PC     uint16 // where the faulting instruction starts
+	Bank   int    // mapped at PC, or -1
+	Cycle  uint64
+	Reason string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("fault at PC=%s (cycle %d): %s", BankedAddr{Bank: f.Bank, Addr: f.PC}, f.Cycle, f.Reason)
+}
+
+// Ticker is implemented by devices that advance with the CPU clock.
+type Ticker interface {
+	Tick(cycles uint64)
+}
+
+// Resetter is implemented by devices that have state to clear on CPU reset.
+type Resetter interface {
+	Reset()
+}
+
+// Logger receives traces, warnings and faults. Replace it to send them
+// elsewhere, or set it to write to io.Discard to silence them.
+var Logger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+
+// NewNANDPU creates a CPU with an empty memory map, ready to have memory and
+// devices attached.
+func NewNANDPU() *NANDPU {
+	c := NANDPU{Trace: true}
+	c.Mem.OpenBus = 0xFF
+
+	c.ResetSP = 0xFFFF
+	c.SP.val = c.ResetSP
+	c.buildRegisterFile()
+
+	Logger.Println("Initialised NANDPU")
+	return &c
+}
+
+// Attach maps a region or device and hooks it into the clock and reset.
+func (c *NANDPU) Attach(start, end uint16, region MemoryRegion, priority int) error {
+	if err := c.Mem.AddRegion(start, end, region, priority); err != nil {
+		return err
+	}
+	if t, ok := region.(Ticker); ok {
+		c.tickers = append(c.tickers, t)
+	}
+	if r, ok := region.(Resetter); ok {
+		c.resetters = append(c.resetters, r)
+	}
+	return nil
+}
+
+// RaiseFault stops the CPU after the current instruction.
+func (c *NANDPU) RaiseFault(reason string) {
+	if c.Fault != nil {
+		return
+	}
+	at := c.where(c.curPC)
+	c.Fault = &Fault{PC: at.Addr, Bank: at.Bank, Cycle: c.Cycles, Reason: reason}
+	Logger.Printf("FAULT: %s", c.Fault)
+}
+
+// where qualifies addr with the bank mapped at it, if any.
+func (c *NANDPU) where(addr uint16) BankedAddr {
+	if c.Resolve == nil {
+		return BankedAddr{Bank: -1, Addr: addr}
+	}
+	return c.Resolve(addr)
+}
+
+// Reset returns the CPU and attached devices to their power-on state.
+// Memory contents and the cycle counter are left alone, as on the real board,
+// except that RAM filled with random bytes is filled again and RAM tracked
+// for uninitialised reads counts as unwritten.
+// A device that resets the CPU part way through an instruction, such as the
+// watchdog expiring while DMA stalls it, does so once the instruction ends,
+// so the CPU still restarts at ResetPC.
+func (c *NANDPU) Reset() {
+	if c.executing {
+		c.resetPending = true
+		return
+	}
+	c.resetPending = false
+	c.PC.val = c.ResetPC
+	c.curPC = c.ResetPC
+	c.INST.val = 0
+	c.INC.val = 0
+	c.SP.val = c.ResetSP
+	c.RegA.val, c.RegB.val, c.RegC.val, c.RegD.val = 0, 0, 0, 0
+	c.RegM.val, c.RegXY.val, c.RegJ.val = 0, 0, 0
+	c.Zero, c.Carry, c.Sign, c.LessThan = false, false, false, false
+	c.Fault = nil
+	c.halt = false
+	for _, r := range c.resetters {
+		r.Reset()
+	}
+	if c.Trace {
+		Logger.Println("Reset NANDPU")
+	}
+}
+
+// Halt stops the CPU at the end of the current instruction, as if it had
+// executed HLT.
+func (c *NANDPU) Halt() {
+	c.halt = true
+}
+
+// Stall holds the CPU for a number of cycles, e.g. while waiting for a shared
+// bus. The devices keep running.
+func (c *NANDPU) Stall(cycles uint64) {
+	c.tick(cycles)
+}
+
+func (c *NANDPU) tick(cycles uint64) {
+	c.Cycles += cycles
+	for _, t := range c.tickers {
+		t.Tick(cycles)
+	}
+}
+
+// The CPU reads and loads PC, INST, INC and SP itself through drive and
+// latch, so the hot paths below skip the access checks on them.
+func (c *NANDPU) getMemVal() byte {
+	pc := c.PC.val
+	if c.hooked {
+		c.PC.drive()
+	}
+	if c.cur != nil && pc-c.curPC < c.cur.length {
+		return c.cur.bytes[pc-c.curPC]
+	}
+	if c.OnProtection != nil && pc != c.curPC {
+		// execute checked the opcode before fetching it.
+		c.checkAccess(pc, PERM_X)
+	}
+	val := c.Mem.Read(pc)
+	if c.Audit != nil {
+		c.Audit.fetch(pc, val)
+	}
+	return val
+}
+
+func (c *NANDPU) getInst() {
+	val := c.getMemVal()
+	if c.hooked {
+		c.INST.latch(val)
+		return
+	}
+	c.INST.val = val
+}
+
+func (c *NANDPU) getReg8FromMem() (byte, Reg8Like) {
+	if c.cur != nil && c.PC.val-c.curPC < c.cur.length {
+		if reg := c.cur.reg8[c.PC.val-c.curPC]; reg != nil {
+			if c.hooked {
+				c.PC.drive()
+			}
+			return c.cur.bytes[c.PC.val-c.curPC], reg
+		}
+	}
+	targetIndex := c.getMemVal()
+	target := c.Reg8List[targetIndex]
+	return targetIndex, target
+}
+func (c *NANDPU) getReg16FromMem() (byte, Reg16Like) {
+	if c.cur != nil && c.PC.val-c.curPC < c.cur.length {
+		if reg := c.cur.reg16[c.PC.val-c.curPC]; reg != nil {
+			if c.hooked {
+				c.PC.drive()
+			}
+			return c.cur.bytes[c.PC.val-c.curPC], reg
+		}
+	}
+	targetIndex := c.getMemVal()
+	target := c.Reg16List[targetIndex]
+	return targetIndex, target
+}
+
+// Updates the Zero and Sign flags based off of the value argument, and Less Than based off of the A and B registers
+func (c *NANDPU) updateFlags(value byte) {
+	c.Zero = value == 0
+	c.Sign = (value >> 7) == 1
+	c.LessThan = c.RegB.Get() < c.RegC.Get()
+}
+
+func (c *NANDPU) increment16(value uint16) {
+	c.INC.latch(value + 1)
+	// We don't use the Set method here, because the INC register is configured to be read-only.
+	// This special logic is the only thing that writes to the INC register.
+}
+
+func (c *NANDPU) decrement16(value uint16) {
+	c.INC.latch(value - 1)
+}
+
+func (c *NANDPU) pcInc() {
+	if c.hooked {
+		c.pcIncHooked()
+		return
+	}
+	c.INC.val = c.PC.val + 1
+	c.PC.val = c.INC.val
+}
+
+// pcIncHooked is kept apart so pcInc stays small enough to inline.
+func (c *NANDPU) pcIncHooked() {
+	c.increment16(c.PC.drive())
+	c.PC.latch(c.INC.drive())
+}
+
+// push returns false, leaving SP alone, if the stack overflows or the store
+// faults.
+func (c *NANDPU) push(val byte) bool {
+	top := c.ResetSP
+	if c.Stack != nil {
+		if !c.checkPush(1) {
+			return false
+		}
+		top = c.Stack.Top
+	}
+	c.store(c.SP.drive(), val)
+	if c.Fault != nil {
+		return false
+	}
+	c.decrement16(c.SP.drive())
+	c.SP.latch(c.INC.drive())
+	c.MaxStackDepth = max(c.MaxStackDepth, c.stackDepth(top))
+	return true
+}
+
+// pop returns false, leaving SP alone, if the stack underflows.
+func (c *NANDPU) pop() (byte, bool) {
+	if c.Stack != nil && !c.checkPop(1) {
+		return 0, false
+	}
+	c.increment16(c.SP.drive())
+	c.SP.latch(c.INC.drive())
+	return c.load(c.SP.drive()), true
+}
+
+// load reads data from memory, first reporting reads that aren't allowed and
+// reads of RAM that hasn't been written since reset.
+func (c *NANDPU) load(addr uint16) byte {
+	c.checkAccess(addr, PERM_R)
+	if c.OnUninitRead != nil {
+		if t, ok := c.Mem.region(addr).(writeTracker); ok && !t.Written(addr) {
+			c.OnUninitRead(addr)
+		}
+	}
+	val := c.Mem.Read(addr)
+	if c.Audit != nil {
+		c.Audit.mem(addr, val, false)
+	}
+	return val
+}
+
+func (c *NANDPU) printFlags() {
+	Logger.Printf("Flag values: Z=%d C=%d S=%d L=%d",
+		boolToInt(c.Zero),
+		boolToInt(c.Carry),
+		boolToInt(c.Sign),
+		boolToInt(c.LessThan),
+	)
+}
+
+func (c *NANDPU) branchLogicImm(condition bool, opcode byte) {
+	c.pcInc()
+	addrLo := c.getMemVal()
+	c.RegJ.Lo.Set(addrLo)
+	c.pcInc()
+	addrHi := c.getMemVal()
+	c.RegJ.Hi.Set(addrHi)
+	if condition {
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("%s (condition met) -> jump to addr 0x%04X", OpcodeNames[opcode], c.RegJ.ForceGet())
+		}
+	} else {
+		if c.Trace {
+			Logger.Printf("%s (condition not met) -> do not jump to addr 0x%04X", OpcodeNames[opcode], c.RegJ.ForceGet())
+		}
+		c.pcInc()
+	}
+}
+
+func (c *NANDPU) branchLogicJ(condition bool, opcode byte) {
+	if condition {
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("%s (condition met) -> jump to addr 0x%04X", OpcodeNames[opcode], c.RegJ.ForceGet())
+		}
+	} else {
+		if c.Trace {
+			Logger.Printf("%s (condition not met) -> do not jump to addr 0x%04X", OpcodeNames[opcode], c.RegJ.ForceGet())
+		}
+		c.pcInc()
+	}
+}
+
+// Step executes a single instruction and advances the clock. It returns false
+// once the CPU has halted or faulted.
+func (c *NANDPU) Step() bool {
+	if c.Fault != nil {
+		return false
+	}
+	if c.Audit != nil {
+		c.Audit.begin(c.PC.val)
+	}
+	c.executing = true
+	running := c.execute()
+	c.executing = false
+	if c.Audit != nil {
+		c.Audit.end()
+	}
+	if c.resetPending {
+		c.Reset()
+		running = true
+	}
+	c.tick(1)
+	if c.halt {
+		c.halt = false
+		running = false
+	}
+	return running && c.Fault == nil
+}
+
+// Run executes up to n instructions, stopping early if the CPU halts or
+// faults. It returns the number executed and whether the CPU is still running.
+func (c *NANDPU) Run(n uint64) (uint64, bool) {
+	if c.Fault != nil {
+		return 0, false
+	}
+	for i := range n {
+		if !c.Step() {
+			return i + 1, false
+		}
+	}
+	return n, true
+}
+
+// Reads made only to be traced use ForceGet, so they don't show up in an
+// audit as reads by the instruction.
+func (c *NANDPU) execute() bool {
+	c.cur = nil
+	c.curPC = c.PC.val
+	c.hooked = c.PC.hooked() || c.INC.hooked() || c.SP.hooked() || c.INST.hooked()
+	if !c.checkAccess(c.PC.val, PERM_X) {
+		return false
+	}
+	if c.SMC != nil {
+		c.SMC.exec(c.PC.val)
+	}
+	if c.ICache != nil && c.Audit == nil && c.OnUninitRead == nil {
+		c.cur = c.ICache.lookup(c.PC.val)
+	}
+	c.getInst()
+
+	if c.Trace {
+		Logger.Printf("ADDR %s", c.where(c.PC.ForceGet()))
+	}
+
+	switch c.INST.val {
+	case OP_NOP:
+		if c.Trace {
+			Logger.Println("No operation.")
+		}
+
+	case OP_CMP:
+		c.updateFlags(c.RegB.Get())
+		c.Carry = (c.RegB.Get() & 0x01) == 1
+		if c.Trace {
+			c.printFlags()
+		}
+
+	case OP_ADD:
+		result := uint16(c.RegB.Get()) + uint16(c.RegC.Get())
+		resultByte := byte(result)
+		c.updateFlags(resultByte)
+		c.Carry = result > 0xFF
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(resultByte)
+		if c.Trace {
+			Logger.Printf("ADD regB (value %d) + regC (value %d) -> %s (new value %d)", prevRegBVal, c.RegC.ForceGet(), Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_SUB:
+		result := c.RegB.Get() - c.RegC.Get()
+		c.updateFlags(result)
+		c.Carry = c.RegC.Get() > c.RegB.Get()
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("SUB regB (value %d) - regC (value %d) -> %s (new value %d)", prevRegBVal, c.RegC.ForceGet(), Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_INC:
+		result := c.RegB.Get() + 1
+		c.updateFlags(result)
+		c.Carry = c.RegB.Get() > result
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("INC regB (value %d) + 1 -> %s (new value %d)", prevRegBVal, Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_DEC:
+		result := c.RegB.Get() - 1
+		c.updateFlags(result)
+		c.Carry = result > c.RegB.Get()
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("DEC regB (value %d) - 1 -> %s (new value %d)", prevRegBVal, Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_NAND:
+		result := ^(c.RegB.Get() & c.RegC.Get())
+		c.updateFlags(result)
+		c.Carry = (result & 0x01) == 1
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("NAND ~(regB (value %d) & regC (value %d)) -> %s (new value %d)", prevRegBVal, c.RegC.ForceGet(), Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_SHR:
+		oldCarry := c.Carry
+		result := (c.RegB.Get() >> 1) | (byte(boolToInt(c.Carry)) << 7)
+		c.updateFlags(result)
+		c.Carry = (c.RegB.Get() >> 7) == 1
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("SHR (regB (value %d) >> 1) | (carry (value %d) << 7) -> %s (new value %d)", prevRegBVal, boolToInt(oldCarry), Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_SHL:
+		oldCarry := c.Carry
+		result := (c.RegB.Get() << 1) | (byte(boolToInt(c.Carry)))
+		c.updateFlags(result)
+		c.Carry = (c.RegB.Get() >> 7) == 1
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevRegBVal := c.RegB.ForceGet()
+		target.Set(result)
+		if c.Trace {
+			Logger.Printf("SHR (regB (value %d) << 1) | carry (value %d) -> %s (new value %d)", prevRegBVal, boolToInt(oldCarry), Reg8Names[targetIndex], target.ForceGet())
+			c.printFlags()
+		}
+
+	case OP_LDI:
+		c.pcInc()
+		val := c.getMemVal()
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevTargetVal := target.ForceGet()
+		target.Set(val)
+		if c.Trace {
+			Logger.Printf("LDI %d into %s (value %d)", val, Reg8Names[targetIndex], prevTargetVal)
+		}
+
+	case OP_LDMI:
+		c.pcInc()
+		addrLo := c.getMemVal()
+		c.RegM.Lo.Set(addrLo)
+		c.pcInc()
+		addrHi := c.getMemVal()
+		c.RegM.Hi.Set(addrHi)
+		val := c.load(c.RegM.Get())
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevTargetVal := target.ForceGet()
+		target.Set(val)
+		if c.Trace {
+			Logger.Printf("LDMI addr 0x%04X (value %d) into %s (value %d)", c.RegM.ForceGet(), val, Reg8Names[targetIndex], prevTargetVal)
+		}
+
+	case OP_LDM:
+		addr := c.RegM.Get()
+		val := c.load(addr)
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		prevTargetVal := target.ForceGet()
+		target.Set(val)
+		if c.Trace {
+			Logger.Printf("LDM from M register (addr 0x%04X) (value %d) into %s (value %d)", addr, val, Reg8Names[targetIndex], prevTargetVal)
+		}
+
+	case OP_STOI:
+		c.pcInc()
+		sourceIndex, source := c.getReg8FromMem()
+		c.pcInc()
+		addrLo := c.getMemVal()
+		c.RegM.Lo.Set(addrLo)
+		c.pcInc()
+		addrHi := c.getMemVal()
+		c.RegM.Hi.Set(addrHi)
+		var prevMemVal byte
+		if c.Trace {
+			prevMemVal = c.Mem.Peek(c.RegM.ForceGet())
+		}
+		c.store(c.RegM.Get(), source.Get())
+		if c.Trace {
+			Logger.Printf("STOI from %s (value %d) into addr 0x%04X (value %d)", Reg8Names[sourceIndex], source.ForceGet(), c.RegM.ForceGet(), prevMemVal)
+		}
+
+	case OP_STO:
+		c.pcInc()
+		sourceIndex, source := c.getReg8FromMem()
+		addr := c.RegM.Get()
+		var prevMemVal byte
+		if c.Trace {
+			prevMemVal = c.Mem.Peek(addr)
+		}
+		c.store(addr, source.Get())
+		if c.Trace {
+			Logger.Printf("STO from %s (value %d) into mem at M register (addr 0x%04X) (value %d)", Reg8Names[sourceIndex], source.ForceGet(), addr, prevMemVal)
+		}
+
+	case OP_PUSH:
+		c.pcInc()
+		sourceIndex, source := c.getReg8FromMem()
+		if !c.push(source.Get()) {
+			return false
+		}
+		if c.Trace {
+			Logger.Printf("PUSH %s (value %d) onto stack", Reg8Names[sourceIndex], source.ForceGet())
+		}
+
+	case OP_POP:
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		val, ok := c.pop()
+		if !ok {
+			return false
+		}
+		target.Set(val)
+		if c.Trace {
+			Logger.Printf("POP stack into %s (value %d)", Reg8Names[targetIndex], target.ForceGet())
+		}
+
+	case OP_MOV8:
+		c.pcInc()
+		sourceIndex, source := c.getReg8FromMem()
+		c.pcInc()
+		targetIndex, target := c.getReg8FromMem()
+		oldTargetVal := target.ForceGet()
+		target.Set(source.Get())
+		if c.Trace {
+			Logger.Printf("MOV8 from %s (value %d) into %s (value %d)", Reg8Names[sourceIndex], source.ForceGet(), Reg8Names[targetIndex], oldTargetVal)
+		}
+
+	case OP_MOV16:
+		c.pcInc()
+		sourceIndex, source := c.getReg16FromMem()
+		c.pcInc()
+		targetIndex, target := c.getReg16FromMem()
+		oldTargetVal := target.ForceGet()
+		target.Set(source.Get())
+		if c.Trace {
+			Logger.Printf("MOV16 from %s (value %d) into %s (value %d)", Reg16Names[sourceIndex], source.ForceGet(), Reg16Names[targetIndex], oldTargetVal)
+		}
+
+	case OP_JMPI:
+		c.pcInc()
+		addrLo := c.getMemVal()
+		c.RegJ.Lo.Set(addrLo)
+		c.pcInc()
+		addrHi := c.getMemVal()
+		c.RegJ.Hi.Set(addrHi)
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("JMPI to addr 0x%04X", c.RegJ.ForceGet())
+		}
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_CALI:
+		if c.Stack != nil && !c.checkPush(2) {
+			return false
+		}
+		// PC drives the bus for each push.
+		if !c.push(byte(c.PC.Get()&0x00FF)) || !c.push(byte((c.PC.Get()&0xFF00)>>8)) {
+			return false
+		}
+
+		c.pcInc()
+		addrLo := c.getMemVal()
+		c.RegJ.Lo.Set(addrLo)
+		c.pcInc()
+		addrHi := c.getMemVal()
+		c.RegJ.Hi.Set(addrHi)
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("CALI addr 0x%04X (SP now 0x%04X)", c.RegJ.ForceGet(), c.SP.ForceGet())
+		}
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_JMP:
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("JMP to addr 0x%04X", c.RegJ.ForceGet())
+		}
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_CALL:
+		if c.Stack != nil && !c.checkPush(2) {
+			return false
+		}
+		c.RegXY.Set(c.PC.Get())
+		if !c.push(c.RegXY.Lo.Get()) || !c.push(c.RegXY.Hi.Get()) {
+			return false
+		}
+
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("CALL addr 0x%04X (SP now 0x%04X)", c.RegJ.ForceGet(), c.SP.ForceGet())
+		}
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_RET:
+		if c.Stack != nil && !c.checkPop(2) {
+			return false
+		}
+		hi, ok := c.pop()
+		if !ok {
+			return false
+		}
+		c.RegJ.Hi.Set(hi)
+		lo, ok := c.pop()
+		if !ok {
+			return false
+		}
+		c.RegJ.Lo.Set(lo)
+
+		c.PC.Set(c.RegJ.Get())
+		if c.Trace {
+			Logger.Printf("RET to addr 0x%04X (SP now 0x%04X)", c.RegJ.ForceGet(), c.SP.ForceGet())
+		}
+
+	case OP_BZSI:
+		condition := c.Zero
+		c.branchLogicImm(condition, OP_BZSI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BZCI:
+		condition := !c.Zero
+		c.branchLogicImm(condition, OP_BZCI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BCSI:
+		condition := c.Carry
+		c.branchLogicImm(condition, OP_BCSI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BCCI:
+		condition := !c.Carry
+		c.branchLogicImm(condition, OP_BCCI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BSSI:
+		condition := c.Sign
+		c.branchLogicImm(condition, OP_BSSI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BSCI:
+		condition := !c.Sign
+		c.branchLogicImm(condition, OP_BSCI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BLSI:
+		condition := c.LessThan
+		c.branchLogicImm(condition, OP_BLSI)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BLCI:
+		condition := !c.LessThan
+		c.branchLogicImm(condition, OP_BLCI)
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_BZS:
+		condition := c.Zero
+		c.branchLogicJ(condition, OP_BZS)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BZC:
+		condition := !c.Zero
+		c.branchLogicJ(condition, OP_BZC)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BCS:
+		condition := c.Carry
+		c.branchLogicJ(condition, OP_BCS)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BCC:
+		condition := !c.Carry
+		c.branchLogicJ(condition, OP_BCC)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BSS:
+		condition := c.Sign
+		c.branchLogicJ(condition, OP_BSS)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BSC:
+		condition := !c.Sign
+		c.branchLogicJ(condition, OP_BSC)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BLS:
+		condition := c.LessThan
+		c.branchLogicJ(condition, OP_BLS)
+		return true // Avoid incrementing the PC after the instruction has finished
+	case OP_BLC:
+		condition := !c.LessThan
+		c.branchLogicJ(condition, OP_BLC)
+		return true // Avoid incrementing the PC after the instruction has finished
+
+	case OP_SPECIAL_HALT:
+		c.pcInc()
+		return false
+	}
+
+	c.pcInc()
+
+	if c.Trace {
+		Logger.Printf("STATE: PC=0x%04X A=0x%02X B=0x%02X C=0x%02X D=0x%02X M=0x%04X XY=0x%04X J=0x%04X SP=0x%04X INC=0x%04X | FLAGS Z=%t C=%t S=%t LT=%t",
+			c.PC.ForceGet(),
+			c.RegA.ForceGet(),
+			c.RegB.ForceGet(),
+			c.RegC.ForceGet(),
+			c.RegD.ForceGet(),
+			c.RegM.ForceGet(),
+			c.RegXY.ForceGet(),
+			c.RegJ.ForceGet(),
+			c.SP.ForceGet(),
+			c.INC.ForceGet(),
+			c.Zero,
+			c.Carry,
+			c.Sign,
+			c.LessThan,
+		)
+	}
+
+	return true
+}
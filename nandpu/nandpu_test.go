@@ -0,0 +1,100 @@
+package nandpu
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	Logger = log.New(io.Discard, "", 0)
+}
+
+// newMachine builds the board o describes with rom as its ROM image, without
+// tracing.
+func newMachine(tb testing.TB, rom []byte, o MachineOptions) *Machine {
+	return newMachineConfig(tb, rom, o.Config())
+}
+
+// newMachineConfig builds the board cfg describes with rom as its default
+// image, without tracing.
+func newMachineConfig(tb testing.TB, rom []byte, cfg *MachineConfig) *Machine {
+	path := filepath.Join(tb.TempDir(), "rom.bin")
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	cfg.DefaultImage = path
+	m, err := NewMachine(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m.CPU.Trace = false
+	return m
+}
+
+// newFib builds the default board running programs/fib.bin, without tracing.
+func newFib(tb testing.TB, icache bool) *NANDPU {
+	rom, err := os.ReadFile("../programs/fib.bin")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c := newMachine(tb, rom, MachineOptions{}).CPU
+	if icache {
+		c.EnableICache()
+	}
+	return c
+}
+
+func benchmarkStep(b *testing.B, icache bool) {
+	c := newFib(b, icache)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if !c.Step() {
+			c.Reset()
+		}
+	}
+}
+
+func benchmarkRun(b *testing.B, icache bool) {
+	c := newFib(b, icache)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for left := uint64(b.N); left > 0; {
+		n, running := c.Run(left)
+		left -= n
+		if !running {
+			c.Reset()
+		}
+	}
+}
+
+func BenchmarkStep(b *testing.B)       { benchmarkStep(b, false) }
+func BenchmarkStepICache(b *testing.B) { benchmarkStep(b, true) }
+func BenchmarkRun(b *testing.B)        { benchmarkRun(b, false) }
+func BenchmarkRunICache(b *testing.B)  { benchmarkRun(b, true) }
+
+// fib.bin ends in the same state whether or not its instructions are cached.
+func TestICacheMatches(t *testing.T) {
+	var cycles [2]uint64
+	var states [2]CPUState
+	var mems [2][0x10000]byte
+	for i, icache := range []bool{false, true} {
+		c := newFib(t, icache)
+		if _, running := c.Run(100000); running || c.Fault != nil {
+			t.Fatalf("icache %t: still running or faulted: %v", icache, c.Fault)
+		}
+		cycles[i], states[i] = c.Cycles, cpuState(c)
+		for addr := range 0x10000 {
+			mems[i][addr] = c.Mem.Peek(uint16(addr))
+		}
+		if icache && c.ICache.Hits == 0 {
+			t.Error("nothing was cached")
+		}
+	}
+	if cycles[0] != cycles[1] || states[0] != states[1] || mems[0] != mems[1] {
+		t.Errorf("without the cache: %d cycles, %+v; with it: %d cycles, %+v", cycles[0], states[0], cycles[1], states[1])
+	}
+}
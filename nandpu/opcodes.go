@@ -1,4 +1,4 @@
-package main
+package nandpu
 
 const (
 	// Args: None
@@ -0,0 +1,143 @@
+package nandpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Perm is a set of access permissions for an address.
+type Perm uint8
+
+const (
+	PERM_R Perm = 1 << iota
+	PERM_W
+	PERM_X
+	PERM_RWX = PERM_R | PERM_W | PERM_X
+)
+
+// Default permissions of region types; other memory is rwx and registered
+// devices are rw.
+var defaultPerms = map[string]Perm{
+	"rom":         PERM_R | PERM_X,
+	"banked_rom":  PERM_R | PERM_X,
+	"bank_select": PERM_R | PERM_W,
+}
+
+func (r RegionConfig) perm() (Perm, error) {
+	if r.Perms != "" {
+		return ParsePerm(r.Perms)
+	}
+	if p, ok := defaultPerms[r.Type]; ok {
+		return p, nil
+	}
+	if _, device := deviceTypes[r.Type]; device {
+		return PERM_R | PERM_W, nil
+	}
+	return PERM_RWX, nil
+}
+
+// ParsePerm parses permissions written like "rwx", "r-x" or "rw".
+func ParsePerm(s string) (Perm, error) {
+	var p Perm
+	for _, ch := range s {
+		switch ch {
+		case 'r':
+			p |= PERM_R
+		case 'w':
+			p |= PERM_W
+		case 'x':
+			p |= PERM_X
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid permissions %q", s)
+		}
+	}
+	return p, nil
+}
+
+func (p Perm) String() string {
+	b := []byte("---")
+	for i, ch := range "rwx" {
+		if p&(1<<i) != 0 {
+			b[i] = byte(ch)
+		}
+	}
+	return string(b)
+}
+
+func permAccess(p Perm) string {
+	switch p {
+	case PERM_R:
+		return "read"
+	case PERM_W:
+		return "write"
+	}
+	return "execute"
+}
+
+// ProtectConfig sets the permissions of an address range over whatever
+// regions are mapped there.
+type ProtectConfig struct {
+	Name  string `toml:"name"`
+	Start uint16 `toml:"start"`
+	End   uint16 `toml:"end"`
+	Perms string `toml:"perms"`
+}
+
+// ParseProtectOptions parses a "start-end=perms" range with hex addresses,
+// e.g. "FF00-FFFF=rw".
+func ParseProtectOptions(spec string) (ProtectConfig, error) {
+	var p ProtectConfig
+	rng, perms, ok := strings.Cut(spec, "=")
+	if !ok {
+		return p, fmt.Errorf("missing permissions in protected range %q", spec)
+	}
+	if _, err := fmt.Sscanf(rng, "%x-%x", &p.Start, &p.End); err != nil {
+		return p, fmt.Errorf("invalid protected range %q: %v", rng, err)
+	}
+	if p.End < p.Start {
+		return p, fmt.Errorf("protected range %q ends before it starts", rng)
+	}
+	if _, err := ParsePerm(perms); err != nil {
+		return p, err
+	}
+	p.Perms = perms
+	return p, nil
+}
+
+// Protect sets the permissions of start-end. Until something is protected,
+// everything is allowed.
+func (m *MemMap) Protect(start, end uint16, p Perm) {
+	if m.perms == nil {
+		m.perms = new([0x10000]Perm)
+		for i := range m.perms {
+			m.perms[i] = PERM_RWX
+		}
+	}
+	for addr := int(start); addr <= int(end); addr++ {
+		m.perms[addr] = p
+	}
+}
+
+// Perm returns the permissions of addr.
+func (m *MemMap) Perm(addr uint16) Perm {
+	if m.perms == nil {
+		return PERM_RWX
+	}
+	return m.perms[addr]
+}
+
+// Allowed reports whether addr may be accessed in every way in p.
+func (m *MemMap) Allowed(addr uint16, p Perm) bool {
+	return m.perms == nil || m.perms[addr]&p == p
+}
+
+// checkAccess reports accesses the memory protection doesn't allow, and
+// returns false if the CPU faulted on one.
+func (c *NANDPU) checkAccess(addr uint16, p Perm) bool {
+	if c.OnProtection == nil || c.Mem.Allowed(addr, p) {
+		return true
+	}
+	c.OnProtection(addr, p)
+	return c.Fault == nil
+}
@@ -0,0 +1,32 @@
+package nandpu
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestOperandFetchProtection(t *testing.T) {
+	o := MachineOptions{Protection: "fault", Protect: []ProtectConfig{{Start: 0x0002, End: 0x0002, Perms: "r"}}}
+	c := newMachine(t, []byte{OP_LDI, 0x12, REG_A}, o).CPU
+	if c.Step() || c.Fault == nil {
+		t.Fatal("fetching an operand without execute permission didn't fault")
+	}
+	if !strings.Contains(c.Fault.Reason, "execute of 0x0002") {
+		t.Errorf("fault %q, want one for executing 0x0002", c.Fault.Reason)
+	}
+}
+
+func TestROMWritesWarnByDefault(t *testing.T) {
+	var out bytes.Buffer
+	defer func(l *log.Logger) { Logger = l }(Logger)
+	Logger = log.New(&out, "", 0)
+
+	c := newMachine(t, []byte{OP_STOI, REG_A, 0x10, 0x00, OP_SPECIAL_HALT}, MachineOptions{}).CPU
+	c.RegA.Set(0x55)
+	c.Run(2)
+	if c.Mem.Peek(0x0010) != 0 || !strings.Contains(out.String(), "WARNING: write of 0x0010 in rom") {
+		t.Errorf("store to ROM wasn't dropped with a warning:\n%s", out.String())
+	}
+}
@@ -0,0 +1,410 @@
+package nandpu
+
+import "fmt"
+
+type Reg8Like interface {
+	Get() byte
+	Set(byte)
+	ForceGet() byte
+}
+
+type Reg16Like interface {
+	Get() uint16
+	Set(uint16)
+	ForceGet() uint16
+}
+
+type AccessFlags struct {
+	CanRead  bool
+	CanWrite bool
+}
+
+func (f *AccessFlags) setAccess(a AccessFlags) { *f = a }
+
+// Hooks8 and Hooks16 observe a register's reads and writes through Get and
+// Set, and the CPU's own use of PC, SP, INC and INST through drive and latch.
+// The Force accessors skip them.
+type Hooks8 struct {
+	OnRead  func(val byte)
+	OnWrite func(old, val byte)
+}
+type Hooks16 struct {
+	OnRead  func(val uint16)
+	OnWrite func(old, val uint16)
+}
+
+func (h *Hooks8) hooked() bool  { return h.OnRead != nil || h.OnWrite != nil }
+func (h *Hooks16) hooked() bool { return h.OnRead != nil || h.OnWrite != nil }
+
+// The accessors check for the unusual cases in one test, keeping them small
+// enough to inline; read and write deal with those cases.
+
+type Reg8 struct {
+	val byte
+	AccessFlags
+	Hooks8
+}
+
+func (r *Reg8) Get() byte {
+	if !r.CanRead || r.OnRead != nil {
+		r.read()
+	}
+	return r.val
+}
+func (r *Reg8) read() {
+	if !r.CanRead {
+		Logger.Panic("attempted to read from Reg8 without read capability")
+	}
+	r.OnRead(r.val)
+}
+func (r *Reg8) ForceGet() byte { return r.val }
+func (r *Reg8) Set(v byte) {
+	if !r.CanWrite || r.OnWrite != nil {
+		r.write(v)
+	}
+	r.val = v
+}
+func (r *Reg8) write(v byte) {
+	if !r.CanWrite {
+		Logger.Panic("attempted to write to Reg8 without write capability")
+	}
+	r.OnWrite(r.val, v)
+}
+
+func (r *Reg8) latch(v byte) {
+	if r.OnWrite != nil {
+		r.OnWrite(r.val, v)
+	}
+	r.val = v
+}
+
+type Reg16 struct {
+	val uint16
+	AccessFlags
+	Hooks16
+}
+
+func (r *Reg16) Get() uint16 {
+	if !r.CanRead || r.OnRead != nil {
+		r.read()
+	}
+	return r.val
+}
+func (r *Reg16) read() {
+	if !r.CanRead {
+		Logger.Panic("attempted to read from Reg16 without read capability")
+	}
+	r.OnRead(r.val)
+}
+func (r *Reg16) ForceGet() uint16 { return r.val }
+func (r *Reg16) Set(v uint16) {
+	if !r.CanWrite || r.OnWrite != nil {
+		r.write(v)
+	}
+	r.val = v
+}
+func (r *Reg16) write(v uint16) {
+	if !r.CanWrite {
+		Logger.Panic("attempted to write to Reg16 without write capability")
+	}
+	r.OnWrite(r.val, v)
+}
+
+// drive and latch are the CPU reading and loading a register as part of an
+// instruction. They call the hooks but skip the access checks, as the CPU
+// loads registers programs can only read.
+func (r *Reg16) drive() uint16 {
+	if r.OnRead != nil {
+		r.OnRead(r.val)
+	}
+	return r.val
+}
+func (r *Reg16) latch(v uint16) {
+	if r.OnWrite != nil {
+		r.OnWrite(r.val, v)
+	}
+	r.val = v
+}
+
+// SplitReg16 is a 16-bit register whose bytes are also registers. Its hooks
+// see accesses to the whole register; the halves have their own.
+type SplitReg16 struct {
+	val uint16
+	AccessFlags
+	Hooks16
+	Hi *splitHi
+	Lo *splitLo
+}
+
+type splitHi struct {
+	parent *SplitReg16
+	AccessFlags
+	Hooks8
+}
+type splitLo struct {
+	parent *SplitReg16
+	AccessFlags
+	Hooks8
+}
+
+func NewSplitReg16(flags16, flagsHi, flagsLo AccessFlags) *SplitReg16 {
+	r := &SplitReg16{AccessFlags: flags16}
+	r.Hi = &splitHi{parent: r, AccessFlags: flagsHi}
+	r.Lo = &splitLo{parent: r, AccessFlags: flagsLo}
+	return r
+}
+
+func (r *SplitReg16) Get() uint16 {
+	if !r.CanRead || r.OnRead != nil {
+		r.read()
+	}
+	return r.val
+}
+func (r *SplitReg16) read() {
+	if !r.CanRead {
+		Logger.Panic("attempted to read from SplitReg16 without read capability")
+	}
+	r.OnRead(r.val)
+}
+func (r *SplitReg16) ForceGet() uint16 { return r.val }
+func (r *SplitReg16) Set(v uint16) {
+	if !r.CanWrite || r.OnWrite != nil {
+		r.write(v)
+	}
+	r.val = v
+}
+func (r *SplitReg16) write(v uint16) {
+	if !r.CanWrite {
+		Logger.Panic("attempted to write to SplitReg16 without write capability")
+	}
+	r.OnWrite(r.val, v)
+}
+func (h *splitHi) Get() byte {
+	if !h.CanRead || h.OnRead != nil {
+		h.read()
+	}
+	return byte(h.parent.val >> 8)
+}
+func (h *splitHi) read() {
+	if !h.CanRead {
+		Logger.Panic("attempted to read from splitHi without read capability")
+	}
+	h.OnRead(h.ForceGet())
+}
+func (h *splitHi) ForceGet() byte {
+	return byte(h.parent.val >> 8)
+}
+func (h *splitHi) Set(v byte) {
+	if !h.CanWrite || h.OnWrite != nil {
+		h.write(v)
+	}
+	h.parent.val = (h.parent.val & 0x00FF) | (uint16(v) << 8)
+}
+func (h *splitHi) write(v byte) {
+	if !h.CanWrite {
+		Logger.Panic("attempted to write to splitHi without write capability")
+	}
+	h.OnWrite(h.ForceGet(), v)
+}
+func (h *splitHi) ForceSet(v byte) {
+	h.parent.val = (h.parent.val & 0x00FF) | (uint16(v) << 8)
+}
+func (l *splitLo) Get() byte {
+	if !l.CanRead || l.OnRead != nil {
+		l.read()
+	}
+	return byte(l.parent.val & 0x00FF)
+}
+func (l *splitLo) read() {
+	if !l.CanRead {
+		Logger.Panic("attempted to read from splitLo without read capability")
+	}
+	l.OnRead(l.ForceGet())
+}
+func (l *splitLo) ForceGet() byte {
+	return byte(l.parent.val & 0x00FF)
+}
+func (l *splitLo) Set(v byte) {
+	if !l.CanWrite || l.OnWrite != nil {
+		l.write(v)
+	}
+	l.parent.val = (l.parent.val & 0xFF00) | uint16(v)
+}
+func (l *splitLo) write(v byte) {
+	if !l.CanWrite {
+		Logger.Panic("attempted to write to splitLo without write capability")
+	}
+	l.OnWrite(l.ForceGet(), v)
+}
+func (l *splitLo) ForceSet(v byte) {
+	l.parent.val = (l.parent.val & 0xFF00) | uint16(v)
+}
+
+const (
+	REG_A  byte = 0x00
+	REG_B  byte = 0x01
+	REG_C  byte = 0x02
+	REG_D  byte = 0x03
+	REG_M1 byte = 0x04
+	REG_M2 byte = 0x05
+	REG_X  byte = 0x06
+	REG_Y  byte = 0x07
+	REG_J1 byte = 0x08
+	REG_J2 byte = 0x09
+
+	REG_M   byte = 0x00
+	REG_XY  byte = 0x01
+	REG_J   byte = 0x02
+	REG_PC  byte = 0x03
+	REG_INC byte = 0x04
+	REG_SP  byte = 0x05
+)
+
+// RegInfo describes a register in the register file.
+type RegInfo struct {
+	Name    string // as in the ISA, e.g. "M1"
+	Field   string // where it lives in the NANDPU, as shown in traces
+	Width   int    // 8 or 16 bits
+	Operand bool   // instructions name it by Index among registers of its width
+	Index   byte
+	Parent  string // the 16-bit register an 8-bit half belongs to
+	High    bool   // the half is the parent's high byte
+	Access  AccessFlags
+}
+
+var (
+	accessRW = AccessFlags{CanRead: true, CanWrite: true}
+	accessRO = AccessFlags{CanRead: true}
+	accessWO = AccessFlags{CanWrite: true}
+)
+
+// RegisterFile is the single description of the NANDPU's registers. The
+// operand lists, names and access flags are all built from it.
+var RegisterFile = []RegInfo{
+	{Name: "A", Field: "RegA", Width: 8, Operand: true, Index: REG_A, Access: accessRW},
+	{Name: "B", Field: "RegB", Width: 8, Operand: true, Index: REG_B, Access: accessRW},
+	{Name: "C", Field: "RegC", Width: 8, Operand: true, Index: REG_C, Access: accessRW},
+	{Name: "D", Field: "RegD", Width: 8, Operand: true, Index: REG_D, Access: accessRW},
+	{Name: "M1", Field: "RegM.Hi", Width: 8, Operand: true, Index: REG_M1, Parent: "M", High: true, Access: accessRW},
+	{Name: "M2", Field: "RegM.Lo", Width: 8, Operand: true, Index: REG_M2, Parent: "M", Access: accessRW},
+	{Name: "X", Field: "RegXY.Hi", Width: 8, Operand: true, Index: REG_X, Parent: "XY", High: true, Access: accessRW},
+	{Name: "Y", Field: "RegXY.Lo", Width: 8, Operand: true, Index: REG_Y, Parent: "XY", Access: accessRW},
+	{Name: "J1", Field: "RegJ.Hi", Width: 8, Operand: true, Index: REG_J1, Parent: "J", High: true, Access: accessWO},
+	{Name: "J2", Field: "RegJ.Lo", Width: 8, Operand: true, Index: REG_J2, Parent: "J", Access: accessWO},
+
+	{Name: "M", Field: "RegM", Width: 16, Operand: true, Index: REG_M, Access: accessRO},
+	{Name: "XY", Field: "RegXY", Width: 16, Operand: true, Index: REG_XY, Access: accessRW},
+	{Name: "J", Field: "RegJ", Width: 16, Operand: true, Index: REG_J, Access: accessRO},
+	{Name: "PC", Field: "PC", Width: 16, Operand: true, Index: REG_PC, Access: accessRW},
+	{Name: "INC", Field: "INC", Width: 16, Operand: true, Index: REG_INC, Access: accessRO},
+	{Name: "SP", Field: "SP", Width: 16, Operand: true, Index: REG_SP, Access: accessRW},
+
+	{Name: "INST", Field: "INST", Width: 8, Access: accessRW},
+}
+
+var Reg8Names, Reg16Names = operandNames(8), operandNames(16)
+
+func operandNames(width int) map[byte]string {
+	names := map[byte]string{}
+	for _, r := range RegisterFile {
+		if r.Operand && r.Width == width {
+			names[r.Index] = r.Field
+		}
+	}
+	return names
+}
+
+// The CPU is built from RegisterFile, so a mistake in it is a bug rather
+// than something to carry on from.
+func init() {
+	if err := checkRegisterFile(RegisterFile); err != nil {
+		panic(err)
+	}
+}
+
+// checkRegisterFile checks that file describes a register file that can be
+// built: unique names, operand indexes numbered from 0 without gaps for each
+// width, and 8-bit halves that split a 16-bit register in two.
+func checkRegisterFile(file []RegInfo) error {
+	byName := map[string]RegInfo{}
+	operands := map[int]map[byte]string{8: {}, 16: {}}
+	for _, r := range file {
+		if _, dup := byName[r.Name]; dup {
+			return fmt.Errorf("register %s is described twice", r.Name)
+		}
+		byName[r.Name] = r
+		if r.Width != 8 && r.Width != 16 {
+			return fmt.Errorf("register %s is %d bits wide", r.Name, r.Width)
+		}
+		if r.Operand {
+			if other, dup := operands[r.Width][r.Index]; dup {
+				return fmt.Errorf("registers %s and %s are both %d-bit operand %d", other, r.Name, r.Width, r.Index)
+			}
+			operands[r.Width][r.Index] = r.Name
+		}
+	}
+	for width, names := range operands {
+		for i := range len(names) {
+			if _, ok := names[byte(i)]; !ok {
+				return fmt.Errorf("no %d-bit register is operand %d", width, i)
+			}
+		}
+	}
+
+	halves := map[string][]RegInfo{}
+	for _, r := range file {
+		if r.Parent == "" {
+			continue
+		}
+		if p, ok := byName[r.Parent]; !ok || p.Width != 16 || p.Parent != "" {
+			return fmt.Errorf("register %s is half of %s, which is not a 16-bit register", r.Name, r.Parent)
+		}
+		if r.Width != 8 {
+			return fmt.Errorf("register %s is half of %s but %d bits wide", r.Name, r.Parent, r.Width)
+		}
+		halves[r.Parent] = append(halves[r.Parent], r)
+	}
+	for parent, hs := range halves {
+		if len(hs) != 2 || hs[0].High == hs[1].High {
+			return fmt.Errorf("register %s needs one high and one low half", parent)
+		}
+	}
+	return nil
+}
+
+// Register returns the register called name in the ISA, or nil.
+func (c *NANDPU) Register(name string) any {
+	return c.registers[name]
+}
+
+// buildRegisterFile sets up the registers as RegisterFile describes them.
+func (c *NANDPU) buildRegisterFile() {
+	c.RegM = NewSplitReg16(AccessFlags{}, AccessFlags{}, AccessFlags{})
+	c.RegXY = NewSplitReg16(AccessFlags{}, AccessFlags{}, AccessFlags{})
+	c.RegJ = NewSplitReg16(AccessFlags{}, AccessFlags{}, AccessFlags{})
+	c.registers = map[string]any{
+		"A": &c.RegA, "B": &c.RegB, "C": &c.RegC, "D": &c.RegD,
+		"M": c.RegM, "XY": c.RegXY, "J": c.RegJ,
+		"PC": &c.PC, "INC": &c.INC, "SP": &c.SP, "INST": &c.INST,
+	}
+
+	c.Reg8List = make([]Reg8Like, len(Reg8Names))
+	c.Reg16List = make([]Reg16Like, len(Reg16Names))
+	for _, r := range RegisterFile {
+		reg := c.registers[r.Name]
+		if parent, ok := c.registers[r.Parent].(*SplitReg16); ok {
+			if r.High {
+				reg = parent.Hi
+			} else {
+				reg = parent.Lo
+			}
+			c.registers[r.Name] = reg
+		}
+		reg.(interface{ setAccess(AccessFlags) }).setAccess(r.Access)
+		if r.Operand && r.Width == 8 {
+			c.Reg8List[r.Index] = reg.(Reg8Like)
+		} else if r.Operand {
+			c.Reg16List[r.Index] = reg.(Reg16Like)
+		}
+	}
+}
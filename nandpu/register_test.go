@@ -0,0 +1,107 @@
+package nandpu
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckRegisterFile(t *testing.T) {
+	if err := checkRegisterFile(RegisterFile); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := map[string]func(file []RegInfo) []RegInfo{
+		"duplicate name": func(file []RegInfo) []RegInfo {
+			return append(file, RegInfo{Name: "A", Width: 8})
+		},
+		"bad width": func(file []RegInfo) []RegInfo {
+			file[0].Width = 12
+			return file
+		},
+		"duplicate operand": func(file []RegInfo) []RegInfo {
+			file[1].Index = REG_A
+			return file
+		},
+		"operand gap": func(file []RegInfo) []RegInfo {
+			return slices.DeleteFunc(file, func(r RegInfo) bool { return r.Name == "B" })
+		},
+		"unknown parent": func(file []RegInfo) []RegInfo {
+			return append(file, RegInfo{Name: "Z", Width: 8, Parent: "Q"})
+		},
+		"8-bit parent": func(file []RegInfo) []RegInfo {
+			return append(file, RegInfo{Name: "Z", Width: 8, Parent: "A"})
+		},
+		"16-bit half": func(file []RegInfo) []RegInfo {
+			return append(file, RegInfo{Name: "Z", Width: 16, Parent: "SP"})
+		},
+		"two high halves": func(file []RegInfo) []RegInfo {
+			for i := range file {
+				if file[i].Name == "Y" {
+					file[i].High = true
+				}
+			}
+			return file
+		},
+		"one half": func(file []RegInfo) []RegInfo {
+			return slices.DeleteFunc(file, func(r RegInfo) bool { return r.Name == "J2" })
+		},
+	}
+	for name, breakFile := range broken {
+		if err := checkRegisterFile(breakFile(slices.Clone(RegisterFile))); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestBuildRegisterFile(t *testing.T) {
+	c := NewNANDPU()
+	reg8 := map[byte]Reg8Like{
+		REG_A: &c.RegA, REG_B: &c.RegB, REG_C: &c.RegC, REG_D: &c.RegD,
+		REG_M1: c.RegM.Hi, REG_M2: c.RegM.Lo,
+		REG_X: c.RegXY.Hi, REG_Y: c.RegXY.Lo,
+		REG_J1: c.RegJ.Hi, REG_J2: c.RegJ.Lo,
+	}
+	for index, want := range reg8 {
+		if c.Reg8List[index] != want {
+			t.Errorf("Reg8List[%d] is not %s", index, Reg8Names[index])
+		}
+	}
+	reg16 := map[byte]Reg16Like{
+		REG_M: c.RegM, REG_XY: c.RegXY, REG_J: c.RegJ,
+		REG_PC: &c.PC, REG_INC: &c.INC, REG_SP: &c.SP,
+	}
+	for index, want := range reg16 {
+		if c.Reg16List[index] != want {
+			t.Errorf("Reg16List[%d] is not %s", index, Reg16Names[index])
+		}
+	}
+
+	// The halves share their parent's value.
+	c.RegXY.Set(0x1234)
+	if c.Reg8List[REG_X].Get() != 0x12 || c.Reg8List[REG_Y].Get() != 0x34 {
+		t.Errorf("XY halves read 0x%02X 0x%02X", c.Reg8List[REG_X].Get(), c.Reg8List[REG_Y].Get())
+	}
+
+	if c.RegJ.Hi.AccessFlags != accessWO || c.RegJ.AccessFlags != accessRO || c.RegM.Lo.AccessFlags != accessRW {
+		t.Error("access flags not taken from RegisterFile")
+	}
+}
+
+// The CPU's own updates of PC and SP call their hooks.
+func TestCPUUpdatesCallHooks(t *testing.T) {
+	c := newMachine(t, []byte{OP_PUSH, REG_A, OP_CALL}, MachineOptions{}).CPU
+	c.RegJ.val = 0x1234
+	var pc, sp []uint16
+	c.PC.OnWrite = func(old, val uint16) { pc = append(pc, val) }
+	c.SP.OnWrite = func(old, val uint16) { sp = append(sp, val) }
+
+	c.Step() // PUSH A
+	if !slices.Equal(pc, []uint16{1, 2}) || !slices.Equal(sp, []uint16{0xFFFE}) {
+		t.Errorf("PUSH: PC writes %X, SP writes %X", pc, sp)
+	}
+	pc, sp = nil, nil
+	c.Step() // CALL
+	if !slices.Equal(pc, []uint16{0x1234}) || !slices.Equal(sp, []uint16{0xFFFD, 0xFFFC}) {
+		t.Errorf("CALL: PC writes %X, SP writes %X", pc, sp)
+	}
+}
@@ -0,0 +1,286 @@
+package nandpu
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Semihosting register offsets from the device's base address.
+const (
+	SEMI_PUTC      uint16 = 0x0 // write a byte to host stdout
+	SEMI_CMD       uint16 = 0x1 // write a command to run it; it completes immediately
+	SEMI_ARG0_LO   uint16 = 0x2
+	SEMI_ARG0_HI   uint16 = 0x3
+	SEMI_ARG1_LO   uint16 = 0x4
+	SEMI_ARG1_HI   uint16 = 0x5
+	SEMI_ARG2_LO   uint16 = 0x6
+	SEMI_ARG2_HI   uint16 = 0x7
+	SEMI_RESULT_LO uint16 = 0x8
+	SEMI_RESULT_HI uint16 = 0x9
+	SEMI_STATUS    uint16 = 0xA // bit 0 set if the last command failed
+	SEMI_CYCLES_0  uint16 = 0xC // CPU cycle count, little endian; reading CYCLES_0 latches bytes 1-3
+	SEMI_CYCLES_1  uint16 = 0xD
+	SEMI_CYCLES_2  uint16 = 0xE
+	SEMI_CYCLES_3  uint16 = 0xF
+
+	SEMI_SIZE uint16 = 0x10
+
+	SEMI_WRITE_STR byte = 0x01 // ARG0: address of a NUL-terminated string to print
+	SEMI_OPEN      byte = 0x02 // ARG0: address of a NUL-terminated path, ARG1: mode; RESULT: handle
+	SEMI_READ      byte = 0x03 // ARG0: handle, ARG1: buffer, ARG2: length; RESULT: bytes read
+	SEMI_WRITE     byte = 0x04 // ARG0: handle, ARG1: buffer, ARG2: length; RESULT: bytes written
+	SEMI_CLOSE     byte = 0x05 // ARG0: handle
+	SEMI_EXIT      byte = 0x06 // ARG0: exit code; halts the CPU
+
+	SEMI_MODE_READ   byte = 0x00
+	SEMI_MODE_WRITE  byte = 0x01 // create or truncate
+	SEMI_MODE_APPEND byte = 0x02
+	SEMI_MODE_RW     byte = 0x03
+
+	semiMaxFiles = 8
+	semiMaxPath  = 256
+)
+
+// Semihost lets a program use the host for output, files and its exit code
+// without any other I/O devices. Files are confined to a sandbox directory;
+// with no directory, file commands fail.
+type Semihost struct {
+	base uint16
+	cpu  *NANDPU
+	dir  string
+
+	args        [3]uint16
+	result      uint16
+	status      byte
+	cyclesLatch uint32
+	files       [semiMaxFiles]*os.File
+
+	Stdout   io.Writer
+	Exited   bool
+	ExitCode int
+}
+
+func init() {
+	RegisterDevice("semihost", SEMI_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.Semihost != nil {
+			return nil, fmt.Errorf("only one semihost device is supported")
+		}
+		var dir string
+		if r.Dir != "" {
+			dir = cfg.imagePath(RegionConfig{Image: r.Dir})
+		}
+		m.Semihost = NewSemihost(r.Base, m.CPU, dir)
+		return m.Semihost, nil
+	})
+}
+
+func NewSemihost(base uint16, cpu *NANDPU, dir string) *Semihost {
+	return &Semihost{base: base, cpu: cpu, dir: dir, Stdout: os.Stdout}
+}
+
+func (s *Semihost) Read(addr uint16) byte {
+	switch addr - s.base {
+	case SEMI_CYCLES_0:
+		s.cyclesLatch = uint32(s.cpu.Cycles)
+	case SEMI_CYCLES_1:
+		return byte(s.cyclesLatch >> 8)
+	case SEMI_CYCLES_2:
+		return byte(s.cyclesLatch >> 16)
+	case SEMI_CYCLES_3:
+		return byte(s.cyclesLatch >> 24)
+	}
+	return s.Peek(addr)
+}
+
+// Peek shows the live cycle count rather than the latched high bytes.
+func (s *Semihost) Peek(addr uint16) byte {
+	offset := addr - s.base
+	switch {
+	case offset >= SEMI_ARG0_LO && offset <= SEMI_ARG2_HI:
+		i := (offset - SEMI_ARG0_LO) / 2
+		return byte(s.args[i] >> (8 * ((offset - SEMI_ARG0_LO) % 2)))
+	case offset == SEMI_RESULT_LO:
+		return byte(s.result)
+	case offset == SEMI_RESULT_HI:
+		return byte(s.result >> 8)
+	case offset == SEMI_STATUS:
+		return s.status
+	case offset >= SEMI_CYCLES_0 && offset <= SEMI_CYCLES_3:
+		return byte(s.cpu.Cycles >> (8 * (offset - SEMI_CYCLES_0)))
+	}
+	return 0x00
+}
+
+func (s *Semihost) Write(addr uint16, val byte) {
+	switch addr - s.base {
+	case SEMI_PUTC:
+		s.Stdout.Write([]byte{val})
+	case SEMI_CMD:
+		s.run(val)
+	default:
+		s.Poke(addr, val)
+	}
+}
+
+// Poke sets arguments and results without printing or running a command.
+func (s *Semihost) Poke(addr uint16, val byte) {
+	offset := addr - s.base
+	switch {
+	case offset >= SEMI_ARG0_LO && offset <= SEMI_ARG2_HI:
+		i := (offset - SEMI_ARG0_LO) / 2
+		shift := 8 * ((offset - SEMI_ARG0_LO) % 2)
+		s.args[i] = (s.args[i] &^ (0xFF << shift)) | (uint16(val) << shift)
+	case offset == SEMI_RESULT_LO:
+		s.result = (s.result & 0xFF00) | uint16(val)
+	case offset == SEMI_RESULT_HI:
+		s.result = (s.result & 0x00FF) | (uint16(val) << 8)
+	case offset == SEMI_STATUS:
+		s.status = val
+	}
+}
+
+func (s *Semihost) run(cmd byte) {
+	result, err := s.command(cmd)
+	s.result = result
+	s.status = 0
+	if err != nil {
+		Logger.Printf("SEMIHOST command 0x%02X failed: %v", cmd, err)
+		s.result = 0xFFFF
+		s.status = 0x01
+	}
+}
+
+func (s *Semihost) command(cmd byte) (uint16, error) {
+	switch cmd {
+	case SEMI_WRITE_STR:
+		str := s.readString(s.args[0], 0x10000)
+		_, err := io.WriteString(s.Stdout, str)
+		return uint16(len(str)), err
+
+	case SEMI_OPEN:
+		return s.open(s.readString(s.args[0], semiMaxPath), byte(s.args[1]))
+
+	case SEMI_READ:
+		f, err := s.file(s.args[0])
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, s.args[2])
+		n, err := f.Read(buf)
+		for i := range n {
+			s.cpu.Mem.Write(s.args[1]+uint16(i), buf[i])
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		return uint16(n), err
+
+	case SEMI_WRITE:
+		f, err := s.file(s.args[0])
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, s.args[2])
+		for i := range buf {
+			buf[i] = s.cpu.Mem.Read(s.args[1] + uint16(i))
+		}
+		n, err := f.Write(buf)
+		return uint16(n), err
+
+	case SEMI_CLOSE:
+		f, err := s.file(s.args[0])
+		if err != nil {
+			return 0, err
+		}
+		s.files[s.args[0]] = nil
+		return 0, f.Close()
+
+	case SEMI_EXIT:
+		s.Exited = true
+		s.ExitCode = int(byte(s.args[0]))
+		Logger.Printf("SEMIHOST exit with code %d at cycle %d", s.ExitCode, s.cpu.Cycles)
+		s.cpu.Halt()
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unknown command")
+}
+
+// readString reads a NUL-terminated string from memory, up to limit bytes.
+func (s *Semihost) readString(addr uint16, limit int) string {
+	var str []byte
+	for len(str) < limit {
+		b := s.cpu.Mem.Read(addr + uint16(len(str)))
+		if b == 0 {
+			break
+		}
+		str = append(str, b)
+	}
+	return string(str)
+}
+
+func (s *Semihost) open(name string, mode byte) (uint16, error) {
+	if s.dir == "" {
+		return 0, errors.New("no semihosting directory")
+	}
+	if !filepath.IsLocal(name) {
+		return 0, fmt.Errorf("%q is outside the semihosting directory", name)
+	}
+	flags := map[byte]int{
+		SEMI_MODE_READ:   os.O_RDONLY,
+		SEMI_MODE_WRITE:  os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+		SEMI_MODE_APPEND: os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+		SEMI_MODE_RW:     os.O_RDWR | os.O_CREATE,
+	}
+	flag, ok := flags[mode]
+	if !ok {
+		return 0, fmt.Errorf("invalid mode 0x%02X", mode)
+	}
+
+	for i, f := range s.files {
+		if f != nil {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(s.dir, name), flag, 0644)
+		if err != nil {
+			return 0, err
+		}
+		s.files[i] = f
+		return uint16(i), nil
+	}
+	return 0, errors.New("too many open files")
+}
+
+func (s *Semihost) file(handle uint16) (*os.File, error) {
+	if int(handle) >= len(s.files) || s.files[handle] == nil {
+		return nil, fmt.Errorf("invalid handle %d", handle)
+	}
+	return s.files[handle], nil
+}
+
+// Tick does nothing: commands complete as soon as they are issued.
+func (s *Semihost) Tick(cycles uint64) {}
+
+// Reset closes any files the program left open.
+func (s *Semihost) Reset() {
+	s.Close()
+	s.args = [3]uint16{}
+	s.result = 0
+	s.status = 0
+	s.Exited = false
+	s.ExitCode = 0
+}
+
+func (s *Semihost) Close() error {
+	var err error
+	for i, f := range s.files {
+		if f != nil {
+			err = cmp.Or(err, f.Close())
+			s.files[i] = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package nandpu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SerialLink connects UARTs on different machines. A link with two ports is a
+// point-to-point cable; with more it is a hub that repeats every byte to all
+// other ports. Collisions are not modelled.
+type SerialLink struct {
+	Name  string
+	Ports []*UART
+	Bytes uint64
+
+	// Trace receives one line per byte sent, shared between links to give a
+	// combined view of the traffic.
+	Trace io.Writer
+}
+
+// Connect attaches a UART to the link, taking over its OnTransmit hook.
+func (l *SerialLink) Connect(u *UART) {
+	l.Ports = append(l.Ports, u)
+	u.OnTransmit = func(val byte) { l.send(u, val) }
+}
+
+func (l *SerialLink) send(from *UART, val byte) {
+	var to []string
+	for _, p := range l.Ports {
+		if p != from {
+			p.Receive(val)
+			to = append(to, p.Name)
+		}
+	}
+	l.Bytes++
+
+	line := fmt.Sprintf("SERIAL %s cycle %d: %s -> %s 0x%02X%s", l.Name, from.cpu.Cycles, from.Name, strings.Join(to, ","), val, printable(val))
+	Logger.Print(line)
+	if l.Trace != nil {
+		fmt.Fprintln(l.Trace, line)
+	}
+}
+
+func printable(val byte) string {
+	if val >= 0x20 && val < 0x7F {
+		return fmt.Sprintf(" '%c'", val)
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package nandpu
+
+// SMCReport logs writes to addresses that have been executed as code, which
+// is how self-modifying code shows up. Only bus writes by the CPU and devices
+// count: debug pokes, the refill of random RAM at reset and writes of the
+// value already there are ignored.
+type SMCReport struct {
+	cpu      *NANDPU
+	executed map[int]*[0x10000 / 8]byte // by bank, -1 for unbanked memory
+
+	Writes uint64
+}
+
+// EnableSMCReport starts recording executed addresses and logging writes to
+// them.
+func (c *NANDPU) EnableSMCReport() *SMCReport {
+	s := &SMCReport{cpu: c, executed: map[int]*[0x10000 / 8]byte{}}
+	c.SMC = s
+	c.Mem.OnWrite = s.write
+	return s
+}
+
+// canonical gives the address code at addr is stored at, seen through a
+// mirror, with the bank mapped there.
+func (s *SMCReport) canonical(addr uint16) BankedAddr {
+	if m, ok := s.cpu.Mem.region(addr).(*Mirror); ok {
+		addr = m.target(addr)
+	}
+	return s.cpu.where(addr)
+}
+
+// exec marks the bytes of the instruction starting at pc as executed.
+func (s *SMCReport) exec(pc uint16) {
+	length := instFormats[s.cpu.Mem.Peek(pc)].length
+	for i := range length {
+		a := s.canonical(pc + i)
+		bits := s.executed[a.Bank]
+		if bits == nil {
+			bits = new([0x10000 / 8]byte)
+			s.executed[a.Bank] = bits
+		}
+		bits[a.Addr/8] |= 1 << (a.Addr % 8)
+	}
+}
+
+// write is called before every bus write, and reports the first change to
+// each executed address.
+func (s *SMCReport) write(addr uint16, val byte) {
+	c := s.cpu
+	a := s.canonical(addr)
+	bits := s.executed[a.Bank]
+	if bits == nil || bits[a.Addr/8]&(1<<(a.Addr%8)) == 0 || c.Mem.Peek(addr) == val {
+		return
+	}
+	bits[a.Addr/8] &^= 1 << (a.Addr % 8)
+	s.Writes++
+	Logger.Printf("SMC: write to %s, which has been executed (PC=%s, cycle %d)", a, c.where(c.curPC), c.Cycles)
+}
@@ -0,0 +1,46 @@
+package nandpu
+
+import "testing"
+
+// smcLoop is a program in RAM that increments the immediate of its own LDI.
+var smcLoop = []byte{OP_LDI, 5, 1, OP_INC, 0, OP_STOI, 0, 0x01, 0x80, OP_JMPI, 0x00, 0x80}
+
+func newSMCMachine(t *testing.T, o MachineOptions) (*NANDPU, *SMCReport) {
+	m := newMachine(t, []byte{OP_JMPI, 0x00, 0x80}, o)
+	c := m.CPU
+	for i, b := range smcLoop {
+		c.Mem.Poke(0x8000+uint16(i), b)
+	}
+	return c, c.EnableSMCReport()
+}
+
+func TestSMCReport(t *testing.T) {
+	for _, icache := range []bool{false, true} {
+		c, smc := newSMCMachine(t, MachineOptions{})
+		if icache {
+			c.EnableICache()
+		}
+		for range 1 + 4*10 {
+			c.Step()
+		}
+		if c.RegA.Get() != 15 || smc.Writes != 10 {
+			t.Errorf("icache %v: A = %d after %d reported writes, want 15 after 10", icache, c.RegA.Get(), smc.Writes)
+		}
+	}
+}
+
+func TestSMCReportIgnoresDebugAndResetWrites(t *testing.T) {
+	c, smc := newSMCMachine(t, MachineOptions{RAMFill: "random", RAMSeed: 1})
+	c.Step()
+	c.Step()
+	c.Mem.Poke(0x8001, 9)
+	c.Mem.Write(0x8000, OP_LDI)
+	c.Reset()
+	if smc.Writes != 0 {
+		t.Errorf("%d writes reported, want 0", smc.Writes)
+	}
+	c.Mem.Write(0x8001, c.Mem.Peek(0x8001)+1)
+	if smc.Writes != 1 {
+		t.Errorf("%d writes reported after changing executed code, want 1", smc.Writes)
+	}
+}
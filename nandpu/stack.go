@@ -0,0 +1,103 @@
+package nandpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// STACK_DUMP_BYTES is how much of the top of the stack a fault shows.
+const STACK_DUMP_BYTES = 64
+
+// StackConfig bounds the stack in a board config. Zero fields are unchecked.
+type StackConfig struct {
+	Top     uint16 `toml:"top"`      // highest stack address, default initial_sp; popping past it underflows
+	Limit   uint16 `toml:"limit"`    // lowest stack address; pushing below it overflows
+	Guard   uint16 `toml:"guard"`    // bytes below the limit that fault on any write
+	DataEnd uint16 `toml:"data_end"` // last address of the data segment, which the stack must not reach
+}
+
+// StackLimits are the bounds checked as the stack grows down from Top.
+type StackLimits struct {
+	Top, Limit, Guard, DataEnd uint16
+}
+
+// guarded reports whether addr is in the guard region below the limit.
+func (s *StackLimits) guarded(addr uint16) bool {
+	return s.Guard > 0 && addr < s.Limit && s.Limit-addr <= s.Guard
+}
+
+// store writes data to memory. Writes into the stack guard, and writes the
+// memory protection faults on, are dropped.
+func (c *NANDPU) store(addr uint16, val byte) {
+	if c.Stack != nil && c.Stack.guarded(addr) {
+		c.stackFault(fmt.Sprintf("write to stack guard at 0x%04X", addr))
+		return
+	}
+	if !c.checkAccess(addr, PERM_W) {
+		return
+	}
+	if c.Audit != nil {
+		c.Audit.mem(addr, val, true)
+	}
+	c.Mem.Write(addr, val)
+}
+
+// checkPush is called before pushing n bytes, and faults and returns false if
+// any of them would leave the stack's bounds.
+func (c *NANDPU) checkPush(n uint16) bool {
+	s := c.Stack
+	switch {
+	case c.SP.val < s.Limit || c.SP.val-s.Limit < n-1:
+		c.stackFault(fmt.Sprintf("stack overflow: push to 0x%04X, below the limit 0x%04X", min(c.SP.val, s.Limit-1), s.Limit))
+	case s.DataEnd > 0 && (c.SP.val <= s.DataEnd || c.SP.val-s.DataEnd < n):
+		c.stackFault(fmt.Sprintf("stack collided with the data segment: push to 0x%04X, data ends at 0x%04X", min(c.SP.val, s.DataEnd), s.DataEnd))
+	default:
+		return true
+	}
+	return false
+}
+
+// checkPop is called before popping n bytes, and faults and returns false if
+// the stack holds fewer.
+func (c *NANDPU) checkPop(n uint16) bool {
+	if c.SP.val >= c.Stack.Top || c.Stack.Top-c.SP.val < n {
+		c.stackFault(fmt.Sprintf("stack underflow: pop of %d bytes with SP at 0x%04X, the top is 0x%04X", n, c.SP.val, c.Stack.Top))
+		return false
+	}
+	return true
+}
+
+func (c *NANDPU) stackFault(reason string) {
+	if c.Fault != nil {
+		return
+	}
+	c.RaiseFault(reason)
+	Logger.Print(c.StackDump())
+}
+
+// StackDump shows the top of the stack, most recent byte first.
+func (c *NANDPU) StackDump() string {
+	top := c.ResetSP
+	if c.Stack != nil {
+		top = c.Stack.Top
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stack at SP=0x%04X, %d bytes deep:", c.SP.val, c.stackDepth(top))
+	for i := 0; i < STACK_DUMP_BYTES && c.SP.val+uint16(i) < top; i++ {
+		addr := c.SP.val + uint16(i) + 1
+		if i%16 == 0 {
+			fmt.Fprintf(&b, "\n  0x%04X:", addr)
+		}
+		fmt.Fprintf(&b, " %02X", c.Mem.Peek(addr))
+	}
+	return b.String()
+}
+
+// stackDepth is how many bytes lie between SP and top. A program can move SP
+// above the top, which leaves the stack empty rather than nearly full.
+func (c *NANDPU) stackDepth(top uint16) uint16 {
+	if c.SP.val >= top {
+		return 0
+	}
+	return top - c.SP.val
+}
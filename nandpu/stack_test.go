@@ -0,0 +1,47 @@
+package nandpu
+
+import "testing"
+
+// A call that would overflow the stack faults before it changes anything.
+func TestCallOverflow(t *testing.T) {
+	c := newMachine(t, []byte{OP_CALL}, MachineOptions{Stack: StackConfig{Limit: 0xFFFF}}).CPU
+	c.RegJ.val, c.RegXY.val = 0x1234, 0x5555
+	if c.Step() || c.Fault == nil {
+		t.Fatal("no fault")
+	}
+	if c.PC.val != 0 || c.SP.val != 0xFFFF || c.RegXY.val != 0x5555 {
+		t.Errorf("PC=0x%04X SP=0x%04X XY=0x%04X", c.PC.val, c.SP.val, c.RegXY.val)
+	}
+}
+
+// A return with one byte on the stack faults without popping it.
+func TestReturnUnderflow(t *testing.T) {
+	c := newMachine(t, []byte{OP_RET}, MachineOptions{Stack: StackConfig{Top: 0xFFFF}}).CPU
+	c.SP.val, c.RegJ.val = 0xFFFE, 0x1234
+	if c.Step() || c.Fault == nil {
+		t.Fatal("no fault")
+	}
+	if c.SP.val != 0xFFFE || c.RegJ.val != 0x1234 {
+		t.Errorf("SP=0x%04X J=0x%04X", c.SP.val, c.RegJ.val)
+	}
+}
+
+func TestPushOverflow(t *testing.T) {
+	c := newMachine(t, []byte{OP_PUSH, REG_A, OP_PUSH, REG_A}, MachineOptions{Stack: StackConfig{Limit: 0xFFFF}}).CPU
+	if !c.Step() {
+		t.Fatal(c.Fault)
+	}
+	if c.Step() || c.Fault == nil || c.SP.val != 0xFFFE {
+		t.Fatalf("SP=0x%04X, fault %v", c.SP.val, c.Fault)
+	}
+}
+
+// Moving SP above the top leaves the stack empty rather than nearly full.
+func TestStackDepthAboveTop(t *testing.T) {
+	c := newMachine(t, []byte{OP_PUSH, REG_A}, MachineOptions{}).CPU
+	c.ResetSP, c.SP.val = 0x9000, 0x9100
+	c.Step()
+	if c.MaxStackDepth != 0 {
+		t.Errorf("MaxStackDepth = %d", c.MaxStackDepth)
+	}
+}
@@ -0,0 +1,362 @@
+package nandpu
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Shared regions are mapped over each CPU's private memory by default.
+const SHARED_PRIORITY = 2
+
+// SystemConfig describes several NANDPUs sharing part of their address space.
+type SystemConfig struct {
+	Arbitration bool           `toml:"arbitration"` // make CPUs wait for each other on the shared bus
+	CPUs        []CPUConfig    `toml:"cpu"`
+	Shared      []RegionConfig `toml:"shared"` // ram, rom, nvram or mailbox regions seen by every CPU
+	Links       []LinkConfig   `toml:"link"`
+
+	dir string
+}
+
+// CPUConfig describes one CPU in a system.
+type CPUConfig struct {
+	Name    string `toml:"name"`    // used in traces and link ports, default "cpu<N>"
+	Machine string `toml:"machine"` // board description; the standard board when empty, with a UART if there are links
+	ROM     string `toml:"rom"`     // program for ROM regions without their own image
+	Ratio   int    `toml:"ratio"`   // cycles run per scheduler round, default 1
+}
+
+// LinkConfig describes a serial cable, or a hub when it has more than two ports.
+type LinkConfig struct {
+	Name  string   `toml:"name"`
+	Ports []string `toml:"ports"` // "cpu" for the CPU's first UART, or "cpu.uart" for a named one
+}
+
+// LoadSystemConfig reads a multi-CPU system description from a TOML file.
+func LoadSystemConfig(path string) (*SystemConfig, error) {
+	cfg := &SystemConfig{}
+	meta, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %q in %s", undecoded[0].String(), path)
+	}
+	if len(cfg.CPUs) == 0 {
+		return nil, fmt.Errorf("no CPUs in %s", path)
+	}
+	cfg.dir = filepath.Dir(path)
+	return cfg, nil
+}
+
+// System schedules one or more machines, interleaving them by cycle.
+type System struct {
+	Machines []*Machine
+	Names    []string
+	Ratios   []int
+	Arbiter  *BusArbiter // nil unless bus arbitration is enabled
+	Links    []*SerialLink
+	Round    uint64
+
+	budget  []uint64
+	stopped []bool
+	shared  []MemoryRegion
+}
+
+// NewSingleSystem wraps a lone machine so it can be run like any other system.
+func NewSingleSystem(m *Machine) *System {
+	return &System{
+		Machines: []*Machine{m},
+		Names:    []string{"cpu0"},
+		Ratios:   []int{1},
+		budget:   []uint64{m.CPU.Cycles},
+		stopped:  []bool{false},
+	}
+}
+
+// NewSystem builds every CPU in the system and maps the shared regions into
+// each of them.
+func NewSystem(cfg *SystemConfig) (*System, error) {
+	s := &System{}
+	if cfg.Arbitration {
+		s.Arbiter = &BusArbiter{sys: s}
+	}
+
+	regionCfg := &MachineConfig{dir: cfg.dir}
+	type sharedRegion struct {
+		start, end uint16
+		region     MemoryRegion
+		priority   int
+	}
+	var shared []sharedRegion
+	for _, r := range cfg.Shared {
+		region, size, err := newSharedRegion(regionCfg, r)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("shared %s region at 0x%04X: %w", r.Type, r.Base, err)
+		}
+		priority := SHARED_PRIORITY
+		if r.Priority != nil {
+			priority = *r.Priority
+		}
+		shared = append(shared, sharedRegion{r.Base, r.Base + uint16(size-1), region, priority})
+		s.shared = append(s.shared, region)
+	}
+
+	for i, cpu := range cfg.CPUs {
+		mcfg := MachineOptions{OpenBus: 0xFF, UART: len(cfg.Links) > 0}.Config()
+		if cpu.Machine != "" {
+			var err error
+			mcfg, err = LoadMachineConfig(regionCfg.imagePath(RegionConfig{Image: cpu.Machine}))
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("CPU %d: %w", i, err)
+			}
+		}
+		if cpu.ROM != "" {
+			mcfg.DefaultImage = regionCfg.imagePath(RegionConfig{Image: cpu.ROM})
+		}
+
+		m, err := NewMachine(mcfg)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("CPU %d: %w", i, err)
+		}
+		s.Machines = append(s.Machines, m)
+		name := cpu.Name
+		if name == "" {
+			name = fmt.Sprintf("cpu%d", i)
+		}
+		s.Names = append(s.Names, name)
+		for _, uart := range m.UARTs {
+			uart.Name = name + "." + uart.Name
+		}
+
+		for _, sh := range shared {
+			region := sh.region
+			if ram, ok := region.(*RAM); ok && ram.path == "" && m.CPU.OnUninitRead != nil {
+				ram.TrackWrites()
+			}
+			if s.Arbiter != nil {
+				region = &arbitratedRegion{MemoryRegion: region, arb: s.Arbiter, cpu: m.CPU}
+			}
+			if err := m.CPU.Attach(sh.start, sh.end, region, sh.priority); err != nil {
+				s.Close()
+				return nil, fmt.Errorf("CPU %d: shared region: %w", i, err)
+			}
+		}
+
+		s.Ratios = append(s.Ratios, max(cpu.Ratio, 1))
+		s.budget = append(s.budget, 0)
+		s.stopped = append(s.stopped, false)
+	}
+
+	for i, l := range cfg.Links {
+		link := &SerialLink{Name: l.Name}
+		if link.Name == "" {
+			link.Name = fmt.Sprintf("link%d", i)
+		}
+		if len(l.Ports) < 2 {
+			s.Close()
+			return nil, fmt.Errorf("link %s needs at least two ports", link.Name)
+		}
+		for _, port := range l.Ports {
+			uart, err := s.uart(port)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("link %s: %w", link.Name, err)
+			}
+			if uart.OnTransmit != nil {
+				s.Close()
+				return nil, fmt.Errorf("link %s: %s is already connected", link.Name, port)
+			}
+			link.Connect(uart)
+		}
+		s.Links = append(s.Links, link)
+	}
+	return s, nil
+}
+
+// uart finds the UART named by a link port.
+func (s *System) uart(port string) (*UART, error) {
+	cpu, name, named := strings.Cut(port, ".")
+	for i, n := range s.Names {
+		if n != cpu {
+			continue
+		}
+		m := s.Machines[i]
+		for _, uart := range m.UARTs {
+			if !named || uart.Name == port {
+				return uart, nil
+			}
+		}
+		if named {
+			return nil, fmt.Errorf("%s has no UART named %q", cpu, name)
+		}
+		return nil, fmt.Errorf("%s has no UART", cpu)
+	}
+	return nil, fmt.Errorf("unknown CPU %q", cpu)
+}
+
+// SetSerialTrace sends a line for every byte on every link to w.
+func (s *System) SetSerialTrace(w io.Writer) {
+	for _, l := range s.Links {
+		l.Trace = w
+	}
+}
+
+// newSharedRegion builds a region that is mapped into several CPUs at once.
+func newSharedRegion(cfg *MachineConfig, r RegionConfig) (MemoryRegion, int, error) {
+	size := r.Size
+	if r.Type == "mailbox" {
+		size = int(MBOX_SIZE)
+	}
+	if size < 1 || size > 0xFFFF || int(r.Base)+size > 0x10000 {
+		return nil, 0, fmt.Errorf("size 0x%X does not fit in the address space", size)
+	}
+
+	switch r.Type {
+	case "ram":
+		return NewRAM(r.Base, uint16(size)), size, nil
+	case "nvram":
+		if r.Image == "" {
+			return nil, 0, fmt.Errorf("nvram needs an image to persist to")
+		}
+		ram, err := NewNVRAM(r.Base, uint16(size), cfg.imagePath(r))
+		return ram, size, err
+	case "rom":
+		_, data, err := cfg.loadImage(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		rom := NewROM(r.Base, uint16(size))
+		rom.Init(data)
+		return rom, size, nil
+	case "mailbox":
+		return NewMailbox(r.Base), size, nil
+	}
+	return nil, 0, fmt.Errorf("region type cannot be shared")
+}
+
+// Step runs one scheduler round, giving each CPU its ratio of cycles. It
+// returns false once every CPU has halted or faulted.
+func (s *System) Step() bool {
+	running := false
+	for i, m := range s.Machines {
+		s.budget[i] += uint64(s.Ratios[i])
+		for !s.stopped[i] && m.CPU.Cycles < s.budget[i] {
+			s.stopped[i] = !m.CPU.Step()
+		}
+		if s.stopped[i] {
+			// Keep a stopped CPU's budget level so it doesn't race ahead on restart.
+			s.budget[i] = m.CPU.Cycles
+		}
+		running = running || !s.stopped[i]
+	}
+	s.Round++
+	return running
+}
+
+// Stopped reports whether a CPU has halted or faulted.
+func (s *System) Stopped(i int) bool { return s.stopped[i] }
+
+// Reset resets every CPU and lets them all run again.
+func (s *System) Reset() {
+	for i, m := range s.Machines {
+		m.CPU.Reset()
+		s.stopped[i] = false
+		s.budget[i] = m.CPU.Cycles
+	}
+}
+
+// SaveNVRAM writes all private and shared battery-backed RAM to its files.
+func (s *System) SaveNVRAM() {
+	for _, m := range s.Machines {
+		m.SaveNVRAM()
+	}
+	s.saveShared()
+}
+
+// HasNVRAM reports whether any CPU or shared region is battery-backed.
+func (s *System) HasNVRAM() bool {
+	for _, m := range s.Machines {
+		if len(m.NVRAM) > 0 {
+			return true
+		}
+	}
+	for _, region := range s.shared {
+		if ram, ok := region.(*RAM); ok && ram.path != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *System) saveShared() {
+	for _, region := range s.shared {
+		if ram, ok := region.(*RAM); ok {
+			if err := ram.Save(); err != nil {
+				Logger.Printf("Failed to save NVRAM to %s: %v", ram.path, err)
+			}
+		}
+	}
+}
+
+// Close flushes and releases every machine in the system.
+func (s *System) Close() {
+	s.saveShared()
+	for _, m := range s.Machines {
+		m.Close()
+	}
+	for _, l := range s.Links {
+		Logger.Printf("Serial link %s carried %d bytes", l.Name, l.Bytes)
+	}
+	if s.Arbiter != nil && s.Arbiter.Contention > 0 {
+		Logger.Printf("Shared bus contention: %d wait cycles", s.Arbiter.Contention)
+	}
+}
+
+// BusArbiter models a shared bus that serves one CPU per scheduler round.
+// A CPU that finds another has already used the bus this round waits a cycle.
+type BusArbiter struct {
+	sys        *System
+	round      uint64
+	owner      *NANDPU
+	Contention uint64
+}
+
+func (a *BusArbiter) acquire(cpu *NANDPU) {
+	if a.owner != nil && a.owner != cpu && a.round == a.sys.Round {
+		cpu.Stall(1)
+		a.Contention++
+	}
+	a.round = a.sys.Round
+	a.owner = cpu
+}
+
+// arbitratedRegion is one CPU's view of a shared region under arbitration.
+// Debug accesses bypass the arbiter.
+type arbitratedRegion struct {
+	MemoryRegion
+	arb *BusArbiter
+	cpu *NANDPU
+}
+
+func (r *arbitratedRegion) Read(addr uint16) byte {
+	r.arb.acquire(r.cpu)
+	return r.MemoryRegion.Read(addr)
+}
+
+func (r *arbitratedRegion) Write(addr uint16, val byte) {
+	r.arb.acquire(r.cpu)
+	r.MemoryRegion.Write(addr, val)
+}
+
+func (r *arbitratedRegion) Written(addr uint16) bool {
+	t, ok := r.MemoryRegion.(writeTracker)
+	return !ok || t.Written(addr)
+}
@@ -0,0 +1,287 @@
+package nandpu
+
+import "fmt"
+
+// Timer register offsets from the timer's base address.
+const (
+	TIMER_CTRL      uint16 = 0x0 // bit 0 enable, bit 1 auto-reload, bit 2 interrupt enable
+	TIMER_STATUS    uint16 = 0x1 // bit 0 overflow; write 1 to clear
+	TIMER_RELOAD_LO uint16 = 0x2
+	TIMER_RELOAD_HI uint16 = 0x3
+	TIMER_COUNT_LO  uint16 = 0x4 // reading the low byte latches the high byte
+	TIMER_COUNT_HI  uint16 = 0x5
+	TIMER_PRESCALE  uint16 = 0x6 // counter decrements every (PRESCALE + 1) cycles
+	TIMER_CYCLES_0  uint16 = 0x8 // free-running cycle counter, little endian
+	TIMER_CYCLES_1  uint16 = 0x9 // reading CYCLES_0 latches bytes 1-3
+	TIMER_CYCLES_2  uint16 = 0xA
+	TIMER_CYCLES_3  uint16 = 0xB
+	TIMER_WDT_CTRL  uint16 = 0xC // bit 0 enable, bit 1 reset the CPU instead of faulting
+	TIMER_WDT_LO    uint16 = 0xD // watchdog timeout in units of 256 cycles; 0 holds the watchdog off
+	TIMER_WDT_HI    uint16 = 0xE
+	TIMER_WDT_FEED  uint16 = 0xF // write TIMER_WDT_KEY to feed the watchdog
+
+	TIMER_SIZE uint16 = 0x10
+
+	TIMER_WDT_KEY byte = 0x5A
+)
+
+const (
+	timerCtrlEnable byte = 1 << iota
+	timerCtrlAutoReload
+	timerCtrlIRQ
+)
+
+const (
+	wdtCtrlEnable byte = 1 << iota
+	wdtCtrlReset
+)
+
+// Timer is a down-counting timer with a free-running cycle counter and a
+// watchdog. It is driven entirely by the CPU cycle count.
+type Timer struct {
+	base uint16
+	cpu  *NANDPU
+
+	ctrl     byte
+	status   byte
+	reload   uint16
+	count    uint16
+	prescale byte
+	divider  byte
+	cycles   uint32
+
+	countLatch  byte
+	cyclesLatch uint32
+
+	wdtCtrl    byte
+	wdtTimeout uint16
+	wdtElapsed uint64
+	wdtFired   bool // the watchdog is resetting the CPU
+
+	OnInterrupt func()
+}
+
+func init() {
+	RegisterDevice("timer", TIMER_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		if m.Timer != nil {
+			return nil, fmt.Errorf("only one timer is supported")
+		}
+		m.Timer = NewTimer(r.Base, m.CPU)
+		return m.Timer, nil
+	})
+}
+
+func NewTimer(base uint16, cpu *NANDPU) *Timer { return &Timer{base: base, cpu: cpu} }
+
+func (t *Timer) Read(addr uint16) byte {
+	switch addr - t.base {
+	case TIMER_COUNT_LO:
+		t.countLatch = byte(t.count >> 8)
+	case TIMER_COUNT_HI:
+		return t.countLatch
+	case TIMER_CYCLES_0:
+		t.cyclesLatch = t.cycles
+	case TIMER_CYCLES_1:
+		return byte(t.cyclesLatch >> 8)
+	case TIMER_CYCLES_2:
+		return byte(t.cyclesLatch >> 16)
+	case TIMER_CYCLES_3:
+		return byte(t.cyclesLatch >> 24)
+	}
+	return t.Peek(addr)
+}
+
+// Peek shows the live counters rather than the latched high bytes.
+func (t *Timer) Peek(addr uint16) byte {
+	switch addr - t.base {
+	case TIMER_CTRL:
+		return t.ctrl
+	case TIMER_STATUS:
+		return t.status
+	case TIMER_RELOAD_LO:
+		return byte(t.reload)
+	case TIMER_RELOAD_HI:
+		return byte(t.reload >> 8)
+	case TIMER_COUNT_LO:
+		return byte(t.count)
+	case TIMER_COUNT_HI:
+		return byte(t.count >> 8)
+	case TIMER_PRESCALE:
+		return t.prescale
+	case TIMER_CYCLES_0:
+		return byte(t.cycles)
+	case TIMER_CYCLES_1:
+		return byte(t.cycles >> 8)
+	case TIMER_CYCLES_2:
+		return byte(t.cycles >> 16)
+	case TIMER_CYCLES_3:
+		return byte(t.cycles >> 24)
+	case TIMER_WDT_CTRL:
+		return t.wdtCtrl
+	case TIMER_WDT_LO:
+		return byte(t.wdtTimeout)
+	case TIMER_WDT_HI:
+		return byte(t.wdtTimeout >> 8)
+	}
+	return 0x00
+}
+
+func (t *Timer) Write(addr uint16, val byte) {
+	switch addr - t.base {
+	case TIMER_CTRL:
+		if t.ctrl&timerCtrlEnable == 0 && val&timerCtrlEnable != 0 {
+			t.count = t.reload
+			t.divider = 0
+		}
+		t.ctrl = val
+	case TIMER_STATUS:
+		t.status &^= val
+	case TIMER_RELOAD_LO:
+		t.reload = (t.reload & 0xFF00) | uint16(val)
+	case TIMER_RELOAD_HI:
+		t.reload = (t.reload & 0x00FF) | (uint16(val) << 8)
+	case TIMER_COUNT_LO:
+		t.count = (t.count & 0xFF00) | uint16(val)
+	case TIMER_COUNT_HI:
+		t.count = (t.count & 0x00FF) | (uint16(val) << 8)
+	case TIMER_PRESCALE:
+		t.prescale = val
+	case TIMER_WDT_CTRL:
+		t.wdtCtrl = val
+		t.wdtElapsed = 0
+	case TIMER_WDT_LO:
+		t.wdtTimeout = (t.wdtTimeout & 0xFF00) | uint16(val)
+	case TIMER_WDT_HI:
+		t.wdtTimeout = (t.wdtTimeout & 0x00FF) | (uint16(val) << 8)
+	case TIMER_WDT_FEED:
+		if val == TIMER_WDT_KEY {
+			t.wdtElapsed = 0
+		}
+	}
+}
+
+// Poke sets register contents without reloading the counter, clearing status
+// or feeding the watchdog.
+func (t *Timer) Poke(addr uint16, val byte) {
+	switch addr - t.base {
+	case TIMER_CTRL:
+		t.ctrl = val
+	case TIMER_STATUS:
+		t.status = val
+	case TIMER_WDT_CTRL:
+		t.wdtCtrl = val
+	case TIMER_WDT_FEED:
+	default:
+		t.Write(addr, val)
+	}
+}
+
+func (t *Timer) Tick(cycles uint64) {
+	for i := range cycles {
+		t.cycles++
+		t.tickCounter()
+		if t.tickWatchdog() {
+			// The cycle counter keeps running past a fault or reset.
+			t.cycles += uint32(cycles - i - 1)
+			return
+		}
+	}
+}
+
+func (t *Timer) tickCounter() {
+	if t.ctrl&timerCtrlEnable == 0 {
+		return
+	}
+	if t.divider < t.prescale {
+		t.divider++
+		return
+	}
+	t.divider = 0
+	t.count--
+	if t.count != 0 {
+		return
+	}
+
+	t.status |= 0x01
+	if t.ctrl&timerCtrlAutoReload != 0 {
+		t.count = t.reload
+	} else {
+		t.ctrl &^= timerCtrlEnable
+	}
+	if t.ctrl&timerCtrlIRQ != 0 && t.OnInterrupt != nil {
+		t.OnInterrupt()
+	}
+}
+
+// tickWatchdog advances the watchdog by one cycle and reports whether it fired.
+// It doesn't run until a timeout has been set, so enabling it before writing
+// the timeout doesn't fire it at once.
+func (t *Timer) tickWatchdog() bool {
+	if t.wdtCtrl&wdtCtrlEnable == 0 || t.wdtTimeout == 0 {
+		return false
+	}
+	t.wdtElapsed++
+	if t.wdtElapsed < uint64(t.wdtTimeout)*256 {
+		return false
+	}
+
+	if t.wdtCtrl&wdtCtrlReset != 0 {
+		Logger.Printf("Watchdog expired at cycle %d, resetting CPU", t.cpu.Cycles)
+		t.wdtFired = true
+		t.cpu.Reset()
+	} else {
+		t.cpu.RaiseFault("watchdog timeout")
+	}
+	return true
+}
+
+// Reset clears the timer. A reset caused by the watchdog leaves the watchdog
+// armed, so it keeps guarding the restarted program.
+func (t *Timer) Reset() {
+	old := *t
+	*t = Timer{base: t.base, cpu: t.cpu, cycles: t.cycles, OnInterrupt: t.OnInterrupt}
+	if old.wdtFired {
+		t.wdtCtrl, t.wdtTimeout = old.wdtCtrl, old.wdtTimeout
+	}
+}
+
+func (t *Timer) SetInterruptHandler(fn func()) { t.OnInterrupt = fn }
+
+// Counter returns the counter's registers for display.
+func (t *Timer) Counter() (count, reload uint16, prescale, ctrl byte) {
+	return t.count, t.reload, t.prescale, t.ctrl
+}
+
+// Watchdog reports whether the watchdog is running, the cycles since it was
+// last fed and the cycles it allows.
+func (t *Timer) Watchdog() (running bool, elapsed, timeout uint64) {
+	return t.wdtCtrl&wdtCtrlEnable != 0 && t.wdtTimeout != 0, t.wdtElapsed, uint64(t.wdtTimeout) * 256
+}
+
+type timerState struct {
+	Ctrl, Status, Prescale, Divider byte
+	Reload, Count                   uint16
+	Cycles, CyclesLatch             uint32
+	CountLatch                      byte
+	WDTCtrl                         byte
+	WDTTimeout                      uint16
+	WDTElapsed                      uint64
+}
+
+func (t *Timer) Snapshot() ([]byte, error) {
+	return encodeState(timerState{
+		t.ctrl, t.status, t.prescale, t.divider, t.reload, t.count, t.cycles, t.cyclesLatch,
+		t.countLatch, t.wdtCtrl, t.wdtTimeout, t.wdtElapsed,
+	})
+}
+
+func (t *Timer) Restore(data []byte) error {
+	var s timerState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	t.ctrl, t.status, t.prescale, t.divider = s.Ctrl, s.Status, s.Prescale, s.Divider
+	t.reload, t.count, t.cycles, t.cyclesLatch = s.Reload, s.Count, s.Cycles, s.CyclesLatch
+	t.countLatch, t.wdtCtrl, t.wdtTimeout, t.wdtElapsed = s.CountLatch, s.WDTCtrl, s.WDTTimeout, s.WDTElapsed
+	return nil
+}
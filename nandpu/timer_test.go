@@ -0,0 +1,33 @@
+package nandpu
+
+import "testing"
+
+func TestWatchdogStaysArmedThroughItsReset(t *testing.T) {
+	m := newMachine(t, []byte{OP_INC, REG_A, OP_JMPI, 0x00, 0x00}, MachineOptions{Timer: true})
+	c := m.CPU
+	c.Mem.Write(TIMER_BASE+TIMER_WDT_LO, 1) // 256 cycles
+	c.Mem.Write(TIMER_BASE+TIMER_WDT_CTRL, wdtCtrlEnable|wdtCtrlReset)
+
+	c.Run(300)
+	if ctrl := c.Mem.Peek(TIMER_BASE + TIMER_WDT_CTRL); ctrl != wdtCtrlEnable|wdtCtrlReset {
+		t.Fatalf("watchdog control 0x%02X after it reset the CPU, want 0x%02X", ctrl, wdtCtrlEnable|wdtCtrlReset)
+	}
+	c.Run(300)
+	if a := c.RegA.Get(); a > 64 {
+		t.Errorf("A = %d, so the watchdog didn't reset the CPU a second time", a)
+	}
+}
+
+func TestTimerCountsCyclesAfterWatchdogFault(t *testing.T) {
+	c := newMachine(t, nil, MachineOptions{}).CPU
+	timer := NewTimer(TIMER_BASE, c)
+	timer.Write(TIMER_BASE+TIMER_WDT_LO, 1)
+	timer.Write(TIMER_BASE+TIMER_WDT_CTRL, wdtCtrlEnable)
+	timer.Tick(1000)
+	if c.Fault == nil {
+		t.Fatal("watchdog didn't fault")
+	}
+	if timer.cycles != 1000 {
+		t.Errorf("cycle counter %d, want 1000", timer.cycles)
+	}
+}
@@ -0,0 +1,185 @@
+package nandpu
+
+// UART register offsets from the device's base address.
+const (
+	UART_DATA   uint16 = 0x0 // write to transmit (ignored while busy); read pops the receive FIFO
+	UART_STATUS uint16 = 0x1 // bit 0 data available, bit 1 transmit busy, bit 2 overrun; write 1 to clear overrun
+	UART_CTRL   uint16 = 0x2 // bit 0 receive interrupt enable, bit 1 transmit done interrupt enable
+	UART_COUNT  uint16 = 0x3 // number of bytes waiting in the receive FIFO
+
+	UART_SIZE uint16 = 0x4
+
+	DEFAULT_BAUD = 9600
+
+	uartFIFOSize = 16
+)
+
+const (
+	uartStatusAvailable byte = 1 << iota
+	uartStatusBusy
+	uartStatusOverrun
+)
+
+const (
+	uartCtrlRxIRQ byte = 1 << iota
+	uartCtrlTxIRQ
+)
+
+// UART is a serial port with a receive FIFO. A transmitted byte occupies the
+// line for ByteCycles cycles, then is handed to OnTransmit, normally a
+// SerialLink that delivers it to the other ports.
+type UART struct {
+	Name       string
+	base       uint16
+	cpu        *NANDPU
+	ByteCycles uint64
+
+	fifo    []byte
+	overrun bool
+	ctrl    byte
+	tx      byte
+	txLeft  uint64
+	busy    bool
+
+	OnTransmit  func(val byte)
+	OnInterrupt func()
+}
+
+func init() {
+	RegisterDevice("uart", UART_SIZE, func(m *Machine, cfg *MachineConfig, r RegionConfig) (Device, error) {
+		baud := r.Baud
+		if baud == 0 {
+			baud = DEFAULT_BAUD
+		}
+		uart := NewUART(r.Base, r.Name, m.CPU, cfg.ClockHz, baud)
+		m.UARTs = append(m.UARTs, uart)
+		return uart, nil
+	})
+}
+
+// NewUART creates a UART whose byte time is derived from the baud rate and
+// clock, counting a start and stop bit per byte.
+func NewUART(base uint16, name string, cpu *NANDPU, clockHz uint64, baud int) *UART {
+	return &UART{Name: name, base: base, cpu: cpu, ByteCycles: max(clockHz*10/uint64(baud), 1)}
+}
+
+func (u *UART) Read(addr uint16) byte {
+	val := u.Peek(addr)
+	if addr-u.base == UART_DATA && len(u.fifo) > 0 {
+		u.fifo = u.fifo[1:]
+	}
+	return val
+}
+
+// Peek shows the next received byte without popping it.
+func (u *UART) Peek(addr uint16) byte {
+	switch addr - u.base {
+	case UART_DATA:
+		if len(u.fifo) == 0 {
+			return 0x00
+		}
+		return u.fifo[0]
+	case UART_STATUS:
+		var status byte
+		if len(u.fifo) > 0 {
+			status |= uartStatusAvailable
+		}
+		if u.busy {
+			status |= uartStatusBusy
+		}
+		if u.overrun {
+			status |= uartStatusOverrun
+		}
+		return status
+	case UART_CTRL:
+		return u.ctrl
+	case UART_COUNT:
+		return byte(len(u.fifo))
+	}
+	return 0x00
+}
+
+func (u *UART) Write(addr uint16, val byte) {
+	switch addr - u.base {
+	case UART_DATA:
+		if u.busy {
+			return
+		}
+		u.tx = val
+		u.txLeft = u.ByteCycles
+		u.busy = true
+	case UART_STATUS:
+		if val&uartStatusOverrun != 0 {
+			u.overrun = false
+		}
+	case UART_CTRL:
+		u.ctrl = val
+	}
+}
+
+// Poke only sets the control register; the FIFO and line are left alone.
+func (u *UART) Poke(addr uint16, val byte) {
+	if addr-u.base == UART_CTRL {
+		u.ctrl = val
+	}
+}
+
+// Receive puts a byte from the line into the FIFO, flagging an overrun if full.
+func (u *UART) Receive(val byte) {
+	if len(u.fifo) >= uartFIFOSize {
+		u.overrun = true
+		return
+	}
+	u.fifo = append(u.fifo, val)
+	if u.ctrl&uartCtrlRxIRQ != 0 && u.OnInterrupt != nil {
+		u.OnInterrupt()
+	}
+}
+
+func (u *UART) Tick(cycles uint64) {
+	if !u.busy {
+		return
+	}
+	if u.txLeft > cycles {
+		u.txLeft -= cycles
+		return
+	}
+	u.busy = false
+	u.txLeft = 0
+	if u.OnTransmit != nil {
+		u.OnTransmit(u.tx)
+	}
+	if u.ctrl&uartCtrlTxIRQ != 0 && u.OnInterrupt != nil {
+		u.OnInterrupt()
+	}
+}
+
+func (u *UART) Reset() {
+	u.fifo = nil
+	u.overrun = false
+	u.ctrl = 0
+	u.busy = false
+	u.txLeft = 0
+}
+
+func (u *UART) SetInterruptHandler(fn func()) { u.OnInterrupt = fn }
+
+type uartState struct {
+	FIFO          []byte
+	Overrun, Busy bool
+	Ctrl, TX      byte
+	TXLeft        uint64
+}
+
+func (u *UART) Snapshot() ([]byte, error) {
+	return encodeState(uartState{u.fifo, u.overrun, u.busy, u.ctrl, u.tx, u.txLeft})
+}
+
+func (u *UART) Restore(data []byte) error {
+	var s uartState
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+	u.fifo, u.overrun, u.busy, u.ctrl, u.tx, u.txLeft = s.FIFO, s.Overrun, s.Busy, s.Ctrl, s.TX, s.TXLeft
+	return nil
+}
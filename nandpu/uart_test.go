@@ -0,0 +1,78 @@
+package nandpu
+
+import "testing"
+
+func TestUARTLoopback(t *testing.T) {
+	const lo, hi = byte(UART_BASE & 0xFF), byte(UART_BASE >> 8)
+	rom := []byte{
+		OP_LDI, 'H', REG_A,
+		OP_STOI, REG_A, lo + byte(UART_DATA), hi,
+		OP_LDMI, lo + byte(UART_COUNT), hi, REG_B, // 0x07: wait for the byte to come back
+		OP_CMP,
+		OP_BZSI, 0x07, 0x00,
+		OP_LDMI, lo + byte(UART_DATA), hi, REG_A,
+		OP_SPECIAL_HALT,
+	}
+	m := newMachine(t, rom, MachineOptions{UART: true})
+	u := m.UARTs[0]
+	u.ByteCycles = 50
+	u.OnTransmit = u.Receive
+
+	c := m.CPU
+	var sent uint64
+	for n := 0; c.Step(); n++ {
+		if n > 1000 {
+			t.Fatal("byte never came back")
+		}
+		if sent == 0 && u.busy {
+			sent = c.Cycles
+		}
+	}
+	if c.Fault != nil {
+		t.Fatal(c.Fault)
+	}
+	if got := c.RegA.Get(); got != 'H' {
+		t.Errorf("received %02X, want %02X", got, 'H')
+	}
+	if c.Cycles-sent < u.ByteCycles {
+		t.Errorf("byte came back after %d cycles, want at least %d", c.Cycles-sent, u.ByteCycles)
+	}
+	if u.Peek(UART_BASE+UART_COUNT) != 0 {
+		t.Error("receive FIFO not empty after reading the byte")
+	}
+}
+
+func TestUARTBusyAndOverrun(t *testing.T) {
+	u := NewUART(UART_BASE, "uart", nil, 1000000, DEFAULT_BAUD)
+	var got []byte
+	u.OnTransmit = func(val byte) { got = append(got, val) }
+
+	u.Write(UART_BASE+UART_DATA, 0x11)
+	u.Write(UART_BASE+UART_DATA, 0x22) // line busy, dropped
+	if u.Peek(UART_BASE+UART_STATUS)&uartStatusBusy == 0 {
+		t.Error("busy not set while transmitting")
+	}
+	u.Tick(u.ByteCycles - 1)
+	if len(got) != 0 {
+		t.Fatal("byte sent before its byte time")
+	}
+	u.Tick(1)
+	if len(got) != 1 || got[0] != 0x11 {
+		t.Errorf("sent %X, want [11]", got)
+	}
+
+	for i := range uartFIFOSize + 1 {
+		u.Receive(byte(i))
+	}
+	status := u.Peek(UART_BASE + UART_STATUS)
+	if status&uartStatusOverrun == 0 || u.Peek(UART_BASE+UART_COUNT) != uartFIFOSize {
+		t.Errorf("status %02X with %d queued, want overrun with %d", status, u.Peek(UART_BASE+UART_COUNT), uartFIFOSize)
+	}
+	if val := u.Read(UART_BASE + UART_DATA); val != 0 {
+		t.Errorf("first byte read %02X, want 00", val)
+	}
+	u.Write(UART_BASE+UART_STATUS, uartStatusOverrun)
+	if u.Peek(UART_BASE+UART_STATUS)&uartStatusOverrun != 0 {
+		t.Error("writing 1 didn't clear the overrun flag")
+	}
+}
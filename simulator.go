@@ -1,45 +1,239 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"time"
+	"slices"
+	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/QEStudios/NANDPUSim/gui"
+	"github.com/QEStudios/NANDPUSim/nandpu"
 	"github.com/sqweek/dialog"
 )
 
+// clockPresets are the clock speeds the GUI offers, from a hand-steppable
+// 1 Hz up to the real machine's 1 MHz; 0 runs as fast as the host can.
+var clockPresets = []clockPreset{
+	{"1 Hz", 1}, {"10 Hz", 10}, {"100 Hz", 100}, {"1 kHz", 1e3},
+	{"10 kHz", 10e3}, {"100 kHz", 100e3}, {"1 MHz", 1e6}, {"Unlimited", 0},
+}
+
+type clockPreset struct {
+	Name string
+	Hz   float64
+}
+
+// clockChoices returns the presets with hz among them, inserted in order if
+// it isn't one already, and its index.
+func clockChoices(hz float64) ([]clockPreset, int) {
+	i := slices.IndexFunc(clockPresets, func(p clockPreset) bool { return p.Hz == hz || p.Hz > hz || p.Hz == 0 })
+	if clockPresets[i].Hz == hz {
+		return clockPresets, i
+	}
+	return slices.Insert(slices.Clone(clockPresets), i, clockPreset{formatHz(hz), hz}), i
+}
+
+func formatHz(hz float64) string {
+	switch {
+	case hz >= 1e6:
+		return fmt.Sprintf("%.2f MHz", hz/1e6)
+	case hz >= 1e3:
+		return fmt.Sprintf("%.2f kHz", hz/1e3)
+	}
+	return fmt.Sprintf("%.1f Hz", hz)
+}
+
 func main() {
-	Logger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+	opts := nandpu.MachineOptions{OpenBus: 0xFF}
+	var headlessOpts HeadlessOptions
+	flag.BoolVar(&opts.EEPROM, "eeprom", false, "emulate in-circuit writes to the AT28C256 ROM")
+	flag.BoolVar(&opts.EEPROMSave, "eeprom-save", false, "write EEPROM changes back to the ROM image on exit")
+	flag.BoolVar(&opts.Timer, "timer", false, "attach the timer and watchdog at 0x7F00")
+	flag.BoolVar(&opts.Keyboard, "keyboard", false, "attach the keyboard controller at 0x7F10")
+	flag.StringVar(&opts.Disk, "disk", "", "disk image to attach as a block device at 0x7F20")
+	flag.BoolVar(&opts.DiskReadOnly, "disk-ro", false, "attach the disk image read-only")
+	flag.BoolVar(&opts.UART, "uart", false, "attach a serial port at 0x7F40")
+	flag.BoolVar(&opts.DMA, "dma", false, "attach the DMA controller at 0x7F50")
+	flag.BoolVar(&opts.Math, "math", false, "attach the multiply/divide unit at 0x7F60")
+	flag.Uint64Var(&opts.MathLatency, "math-latency", 0, "cycles per math unit operation (0 for the defaults: 8 for MUL8, 16 for MUL16 and DIV)")
+	flag.BoolVar(&opts.Semihost, "semihost", false, "attach the semihosting device at 0x7F70")
+	flag.StringVar(&opts.SemihostDir, "semihost-dir", "", "directory semihosted programs may open files in")
+	flag.Func("nvram", "battery-backed RAM range and file as start-end=path, e.g. 8000-80FF=save.bin (repeatable)", func(spec string) error {
+		nv, err := nandpu.ParseNVRAMOptions(spec)
+		if err != nil {
+			return err
+		}
+		opts.NVRAM = append(opts.NVRAM, nv)
+		return nil
+	})
+	flag.BoolVar(&opts.ROMBanks, "rom-banks", false, "bank ROM images larger than 32K into the 0x4000-0x7FFF window")
+	flag.IntVar(&opts.RAMBanks, "ram-banks", 0, "number of 16K RAM banks in the 0x8000-0xBFFF window (0 disables banking)")
+	flag.StringVar(&opts.Unmapped, "unmapped", "ignore", "what to do on accesses to unmapped addresses: ignore, warn or fault")
+	flag.StringVar(&opts.UninitRead, "uninit", "ignore", "what to do on reads of RAM not written since reset: ignore, warn or fault")
+	flag.StringVar(&opts.RAMFill, "ram-fill", "zero", "RAM contents at power-on and reset: zero or random")
+	flag.Uint64Var(&opts.RAMSeed, "ram-seed", 0, "seed for -ram-fill random")
+	flag.StringVar(&opts.Protection, "protection", "warn", "what to do on accesses the memory permissions don't allow, such as stores to ROM: ignore, warn or fault")
+	flag.Func("protect", "permissions for an address range as start-end=perms, e.g. FF00-FFFF=rw (repeatable)", func(spec string) error {
+		p, err := nandpu.ParseProtectOptions(spec)
+		if err != nil {
+			return err
+		}
+		opts.Protect = append(opts.Protect, p)
+		return nil
+	})
+	hexFlag := func(name, usage string, val *uint16) {
+		flag.Func(name, usage, func(s string) error {
+			v, err := strconv.ParseUint(s, 0, 16)
+			*val = uint16(v)
+			return err
+		})
+	}
+	hexFlag("stack-limit", "lowest address the stack may grow down to; pushing below it faults", &opts.Stack.Limit)
+	hexFlag("stack-guard", "number of bytes below -stack-limit that fault when written", &opts.Stack.Guard)
+	hexFlag("data-end", "last address of the program's data; the stack reaching it faults", &opts.Stack.DataEnd)
+	flag.Func("open-bus", "value read from unmapped addresses (default 0xFF)", func(s string) error {
+		val, err := strconv.ParseUint(s, 0, 8)
+		opts.OpenBus = byte(val)
+		return err
+	})
+	// The flags so far describe the board, which -machine and -system replace.
+	boardFlags := map[string]bool{}
+	flag.VisitAll(func(f *flag.Flag) { boardFlags[f.Name] = true })
+	machinePath := flag.String("machine", "", "TOML board description to use instead of the standard memory map")
+	systemPath := flag.String("system", "", "TOML description of several CPUs sharing a bus or linked by serial")
+	serialTracePath := flag.String("serial-trace", "", "write traffic on all serial links to this file")
+	romPath := flag.String("rom", "", "ROM image to load (prompts for a file when empty)")
+	headless := flag.Bool("headless", false, "run without the GUI until the program halts, exiting with the program's semihosting exit code (up to 119, higher codes become 119), 120 if a CPU faults or 121 at -max-steps")
+	trace := flag.Bool("trace", true, "log every instruction (slow; turn off for long runs)")
+	audit := flag.Bool("audit", false, "log every register and memory access each instruction makes (bypasses -icache)")
+	auditReport := flag.Bool("audit-report", false, "print the bus transfers of every opcode, flagging conflicts and reads of write-only registers, and exit")
+	icache := flag.Bool("icache", false, "cache decoded instructions from ROM and RAM (bypassed while checking uninitialised reads)")
+	reportSMC := flag.Bool("report-smc", false, "log writes to memory that has been executed as code")
+	bench := flag.Uint64("bench", 0, "run this many instructions without tracing, restarting the program when it halts, and report the speed")
+	flag.Uint64Var(&headlessOpts.MaxSteps, "max-steps", 0, "stop a headless run after this many steps (0 for no limit)")
+	flag.StringVar(&headlessOpts.InputPath, "input", "", "keyboard input script for headless runs (defaults to stdin)")
+	flag.Parse()
+
+	if *auditReport {
+		nandpu.WriteAuditReport(os.Stdout)
+		return
+	}
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		Logger.Fatalf("Failed to get current working directory: %v", err)
+	if *machinePath != "" || *systemPath != "" {
+		var ignored []string
+		flag.Visit(func(f *flag.Flag) {
+			if boardFlags[f.Name] {
+				ignored = append(ignored, "-"+f.Name)
+			}
+		})
+		if len(ignored) > 0 {
+			nandpu.Logger.Fatalf("%s can't be used with -machine or -system; describe the board in the config instead", strings.Join(ignored, ", "))
+		}
 	}
 
-	path, err := dialog.
-		File().
-		Title("Open BIN file").
-		Filter("Binary files", "bin").
-		SetStartDir(cwd).
-		Load()
-	if err != nil {
-		Logger.Fatalf("Failed to select file: %v", err)
+	var sysCfg *nandpu.SystemConfig
+	cfg := opts.Config()
+	if *systemPath != "" {
+		var err error
+		sysCfg, err = nandpu.LoadSystemConfig(*systemPath)
+		if err != nil {
+			nandpu.Logger.Fatalf("Failed to load system config: %v", err)
+		}
+		nandpu.Logger.Printf("Using system config %s with %d CPUs", *systemPath, len(sysCfg.CPUs))
+	} else if *machinePath != "" {
+		var err error
+		cfg, err = nandpu.LoadMachineConfig(*machinePath)
+		if err != nil {
+			nandpu.Logger.Fatalf("Failed to load machine config: %v", err)
+		}
+		nandpu.Logger.Printf("Using machine config %s", *machinePath)
 	}
 
-	data, err := os.ReadFile(path)
+	cfg.DefaultImage = *romPath
+	if sysCfg == nil && cfg.NeedsROM() && cfg.DefaultImage == "" {
+		if *headless || *bench > 0 {
+			nandpu.Logger.Fatalf("A ROM image must be given with -rom in headless mode")
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			nandpu.Logger.Fatalf("Failed to get current working directory: %v", err)
+		}
+
+		cfg.DefaultImage, err = dialog.
+			File().
+			Title("Open BIN file").
+			Filter("Binary files", "bin").
+			SetStartDir(cwd).
+			Load()
+		if err != nil {
+			nandpu.Logger.Fatalf("Failed to select file: %v", err)
+		}
+	}
+
+	var serialTrace io.Writer
+	if *serialTracePath != "" {
+		f, err := os.Create(*serialTracePath)
+		if err != nil {
+			nandpu.Logger.Fatalf("Failed to create serial trace: %v", err)
+		}
+		defer f.Close()
+		serialTrace = f
+	}
+
+	newSystem := func(rom string) (*nandpu.System, error) {
+		var s *nandpu.System
+		if sysCfg != nil {
+			var err error
+			s, err = nandpu.NewSystem(sysCfg)
+			if err != nil {
+				return nil, err
+			}
+			if serialTrace != nil {
+				s.SetSerialTrace(serialTrace)
+			}
+		} else {
+			cfg.DefaultImage = rom
+			m, err := nandpu.NewMachine(cfg)
+			if err != nil {
+				return nil, err
+			}
+			s = nandpu.NewSingleSystem(m)
+		}
+		for _, m := range s.Machines {
+			m.CPU.Trace = *trace
+			if *icache {
+				m.CPU.EnableICache()
+			}
+			if *reportSMC {
+				m.CPU.EnableSMCReport()
+			}
+			if *audit {
+				m.CPU.EnableAudit(func(ia *nandpu.InstAudit) { nandpu.Logger.Print(ia) })
+			}
+		}
+		return s, nil
+	}
+	sys, err := newSystem(cfg.DefaultImage)
 	if err != nil {
-		Logger.Fatalf("Failed to read file: %v", err)
+		nandpu.Logger.Fatalf("Failed to build machine: %v", err)
 	}
 
-	Logger.Printf("Loaded %d bytes from %s\n", len(data), path)
+	if *bench > 0 {
+		os.Exit(runBenchmark(sys, *bench))
+	}
+	if *headless {
+		os.Exit(runHeadless(sys, headlessOpts))
+	}
 
 	MainApp := app.New()
 	MainApp.Settings().SetTheme(theme.DarkTheme())
@@ -48,13 +242,12 @@ func main() {
 	Wnd.Resize(fyne.NewSize(800, 600))
 	Wnd.SetFixedSize(true)
 
-	nandpu := NewNANDPU(data)
+	// state is the latest snapshot from the controller, only touched on the
+	// UI goroutine.
+	var state *nandpu.RunState
+	var ctrl *nandpu.Controller
 	var updateGUIValues func()
-
-	running := false
-	stepNum := 0
-	speed := binding.NewFloat()
-	speed.Set(50)
+	var memList *widget.List
 
 	createFixedLabel := func() (*widget.Label, *fyne.Container) {
 		value := widget.NewLabel("0x0000")
@@ -64,6 +257,7 @@ func main() {
 	pcLabel, pcLabelContainer := createFixedLabel()
 	spLabel, spLabelContainer := createFixedLabel()
 	incLabel, incLabelContainer := createFixedLabel()
+	bankLabel, bankLabelContainer := createFixedLabel()
 
 	aLabel, aLabelContainer := createFixedLabel()
 	bLabel, bLabelContainer := createFixedLabel()
@@ -94,52 +288,142 @@ func main() {
 	var stepBtn *widget.Button
 	var resetBtn *widget.Button
 
+	// Device panels belong to one machine, so they are closed whenever the
+	// machine shown changes.
+	var devicesWnd fyne.Window
+	closeDevices := func() {
+		if devicesWnd != nil {
+			devicesWnd.Close()
+		}
+	}
+
+	powerCycleCheck := widget.NewCheck("Power cycle", nil)
+	powerCycleCheck.SetChecked(true)
+
 	runBtn = widget.NewButton("Run", func() {
-		if running {
+		if state == nil {
+			return
+		}
+		if state.Running {
 			fmt.Println("Stop button clicked")
-			running = false
+			ctrl.Pause()
 		} else {
 			fmt.Println("Run button clicked")
-			running = true
+			ctrl.Run()
 		}
-		updateGUIValues()
 	})
 	stepBtn = widget.NewButton("Step", func() {
 		fmt.Println("Step button clicked")
-		nandpu.Step()
-		stepNum += 1
-		updateGUIValues()
+		ctrl.Step(1)
 	})
 	resetBtn = widget.NewButton("Reset", func() {
 		fmt.Println("Reset button clicked")
-		nandpu = NewNANDPU(data)
-		stepNum = 0
-		updateGUIValues()
+		if powerCycleCheck.Checked {
+			closeDevices()
+		}
+		ctrl.Reset(powerCycleCheck.Checked)
+	})
+
+	loadBtn := widget.NewButton("Load", func() {
+		fmt.Println("Load button clicked")
+		cwd, err := os.Getwd()
+		if err != nil {
+			nandpu.Logger.Printf("Failed to get current working directory: %v", err)
+			return
+		}
+		rom, err := dialog.
+			File().
+			Title("Open BIN file").
+			Filter("Binary files", "bin").
+			SetStartDir(cwd).
+			Load()
+		if err != nil {
+			nandpu.Logger.Printf("Failed to select file: %v", err)
+			return
+		}
+		closeDevices()
+		ctrl.Load(rom)
 	})
+	// A system config names its own ROMs.
+	if sysCfg != nil {
+		loadBtn.Hide()
+	}
+
+	saveBtn := widget.NewButton("Save NVRAM", func() {
+		fmt.Println("Save NVRAM button clicked")
+		ctrl.SaveNVRAM()
+	})
+	if !sys.HasNVRAM() {
+		saveBtn.Hide()
+	}
 
-	stringSpeed := binding.NewString()
-	speedVal, _ := speed.Get()
-	stringSpeed.Set(fmt.Sprintf("%.1fms", speedVal))
-	speedLabel := widget.NewLabelWithData(stringSpeed)
-	speedSlider := widget.NewSliderWithData(0.1, 150.0, speed)
-	speedSlider.Step = 0.1
+	cpuNames := sys.Names
+	cpuSelect := widget.NewSelect(cpuNames, func(name string) {
+		closeDevices()
+		if ctrl != nil {
+			ctrl.Select(slices.Index(cpuNames, name))
+		}
+	})
+	cpuSelect.SetSelectedIndex(0)
+	if len(sys.Machines) == 1 {
+		cpuSelect.Hide()
+	}
+
+	devicesBtn := widget.NewButton("Devices", func() {
+		fmt.Println("Devices button clicked")
+		if state == nil {
+			return
+		}
+		closeDevices()
+		var panels []fyne.CanvasObject
+		var reads []func() func()
+		for _, d := range state.Devices {
+			if panel, read, ok := gui.Panel(d.Device); ok {
+				panels = append(panels, panel)
+				reads = append(reads, read)
+			}
+		}
+		if len(panels) == 0 {
+			panels = append(panels, widget.NewLabel("No attached device has a panel"))
+		}
+		devicesWnd = MainApp.NewWindow(state.Names[state.Selected] + " devices")
+		devicesWnd.SetContent(container.NewVScroll(container.NewVBox(panels...)))
+		devicesWnd.Resize(fyne.NewSize(400, 300))
+		generation := state.Generation
+		devicesWnd.SetOnClosed(func() {
+			devicesWnd = nil
+			ctrl.SetPanels(generation, nil)
+		})
+		ctrl.SetPanels(generation, reads)
+		devicesWnd.Show()
+	})
 
-	speedSliderContainer := container.NewGridWrap(fyne.NewSize(200, 40), speedSlider)
+	// Start at the clock speed the board is configured with.
+	clocks, initialClock := clockChoices(float64(sys.Machines[0].ClockHz))
+	var clockNames []string
+	for _, p := range clocks {
+		clockNames = append(clockNames, p.Name)
+	}
+	clockHz := clocks[initialClock].Hz
+	clockSelect := widget.NewSelect(clockNames, func(name string) {
+		clockHz = clocks[slices.Index(clockNames, name)].Hz
+		if ctrl != nil {
+			ctrl.SetClock(clockHz)
+		}
+	})
+	clockSelect.SetSelectedIndex(initialClock)
+	hzLabel := widget.NewLabel("")
 
 	btnRow := container.NewHBox(
-		runBtn, stepBtn, resetBtn, stepNumLabel, speedSliderContainer, speedLabel,
+		runBtn, stepBtn, resetBtn, powerCycleCheck, loadBtn, saveBtn, cpuSelect, devicesBtn, stepNumLabel, clockSelect, hzLabel,
 	)
 
-	speed.AddListener(binding.NewDataListener(func() {
-		speedVal, _ := speed.Get()
-		stringSpeed.Set(fmt.Sprintf("%.1fms", speedVal))
-	}))
-
 	regRow1 := container.NewHBox(
 		widget.NewSeparator(),
 		widget.NewLabel("PC"), pcLabelContainer, widget.NewSeparator(),
 		widget.NewLabel("SP"), spLabelContainer, widget.NewSeparator(),
 		widget.NewLabel("INC"), incLabelContainer, widget.NewSeparator(),
+		widget.NewLabel("Bank"), bankLabelContainer, widget.NewSeparator(),
 	)
 
 	regRow2 := container.NewHBox(
@@ -214,7 +498,7 @@ func main() {
 		widget.NewSeparator(),
 	)
 
-	createMemoryList := func(mem *MemMap) *widget.List {
+	createMemoryList := func() *widget.List {
 		const rowSize = 16
 		const totalCells = 65536 // full 16-bit address space
 
@@ -235,9 +519,12 @@ func main() {
 			},
 			func(id widget.ListItemID, item fyne.CanvasObject) {
 				row := item.(*fyne.Container)
+				if state == nil {
+					return
+				}
 				for i := 0; i < rowSize; i++ {
 					addr := uint16(id*rowSize + i)
-					byteValue := mem.Read(addr)
+					byteValue := state.Mem[addr]
 					label := row.Objects[i].(*widget.Label)
 					label.SetText(fmt.Sprintf("%02X", byteValue))
 				}
@@ -245,7 +532,7 @@ func main() {
 		)
 	}
 
-	memList := createMemoryList(&nandpu.Mem)
+	memList = createMemoryList()
 
 	mainContainer := container.NewBorder(
 		regContainer, nil, nil, nil,
@@ -255,40 +542,68 @@ func main() {
 	content := container.NewStack(mainContainer)
 	Wnd.SetContent(content)
 
+	Wnd.Canvas().SetOnTypedRune(func(r rune) {
+		if r < 0x80 {
+			ctrl.Key(byte(r))
+		}
+	})
+	Wnd.Canvas().SetOnTypedKey(func(ev *fyne.KeyEvent) {
+		switch ev.Name {
+		case fyne.KeyReturn, fyne.KeyEnter:
+			ctrl.Key('\n')
+		case fyne.KeyBackspace:
+			ctrl.Key(0x08)
+		case fyne.KeyTab:
+			ctrl.Key('\t')
+		case fyne.KeyEscape:
+			ctrl.Key(0x1B)
+		}
+	})
+
 	updateGUIValues = func() {
-		pcLabel.SetText(fmt.Sprintf("0x%04X", nandpu.PC.val))
-		spLabel.SetText(fmt.Sprintf("0x%04X", nandpu.SP.val))
-		incLabel.SetText(fmt.Sprintf("0x%04X", nandpu.INC.val))
-
-		aLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegA.val))
-		bLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegB.val))
-		cLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegC.val))
-		dLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegD.val))
-
-		mLabel.SetText(fmt.Sprintf("0x%04X", nandpu.RegM.val))
-		xyLabel.SetText(fmt.Sprintf("0x%04X", nandpu.RegXY.val))
-		jLabel.SetText(fmt.Sprintf("0x%04X", nandpu.RegJ.val))
-
-		m1Label.SetText(fmt.Sprintf("0x%02X", nandpu.RegM.Lo.ForceGet()))
-		m2Label.SetText(fmt.Sprintf("0x%02X", nandpu.RegM.Hi.ForceGet()))
-		xLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegXY.Lo.ForceGet()))
-		yLabel.SetText(fmt.Sprintf("0x%02X", nandpu.RegXY.Hi.ForceGet()))
-		j1Label.SetText(fmt.Sprintf("0x%02X", nandpu.RegJ.Lo.ForceGet()))
-		j2Label.SetText(fmt.Sprintf("0x%02X", nandpu.RegJ.Hi.ForceGet()))
-
-		zeroLabel.SetText(fmt.Sprintf("%t", nandpu.Zero))
-		carryLabel.SetText(fmt.Sprintf("%t", nandpu.Carry))
-		signLabel.SetText(fmt.Sprintf("%t", nandpu.Sign))
-		lessThanLabel.SetText(fmt.Sprintf("%t", nandpu.LessThan))
-
-		stepNumLabel.SetText(fmt.Sprintf("Step: %d", stepNum))
-
-		if running {
+		cpu := state.CPU
+		pcLabel.SetText(fmt.Sprintf("0x%04X", cpu.PC))
+		spLabel.SetText(fmt.Sprintf("0x%04X", cpu.SP))
+		incLabel.SetText(fmt.Sprintf("0x%04X", cpu.INC))
+		bankLabel.SetText(state.Bank)
+		for _, show := range state.Panels {
+			show()
+		}
+
+		aLabel.SetText(fmt.Sprintf("0x%02X", cpu.A))
+		bLabel.SetText(fmt.Sprintf("0x%02X", cpu.B))
+		cLabel.SetText(fmt.Sprintf("0x%02X", cpu.C))
+		dLabel.SetText(fmt.Sprintf("0x%02X", cpu.D))
+
+		mLabel.SetText(fmt.Sprintf("0x%04X", cpu.M))
+		xyLabel.SetText(fmt.Sprintf("0x%04X", cpu.XY))
+		jLabel.SetText(fmt.Sprintf("0x%04X", cpu.J))
+
+		m1Label.SetText(fmt.Sprintf("0x%02X", cpu.M1))
+		m2Label.SetText(fmt.Sprintf("0x%02X", cpu.M2))
+		xLabel.SetText(fmt.Sprintf("0x%02X", cpu.X))
+		yLabel.SetText(fmt.Sprintf("0x%02X", cpu.Y))
+		j1Label.SetText(fmt.Sprintf("0x%02X", cpu.J1))
+		j2Label.SetText(fmt.Sprintf("0x%02X", cpu.J2))
+
+		zeroLabel.SetText(fmt.Sprintf("%t", cpu.Zero))
+		carryLabel.SetText(fmt.Sprintf("%t", cpu.Carry))
+		signLabel.SetText(fmt.Sprintf("%t", cpu.Sign))
+		lessThanLabel.SetText(fmt.Sprintf("%t", cpu.LessThan))
+
+		stepNumLabel.SetText(fmt.Sprintf("Step: %d", state.Steps))
+		if state.Running {
+			hzLabel.SetText(formatHz(state.Hz))
+		} else {
+			hzLabel.SetText("")
+		}
+
+		if state.Running {
 			runBtn.SetText("Stop")
 			stepBtn.Disable()
 			resetBtn.Disable()
 		} else {
-			if stepNum > 0 {
+			if state.Steps > 0 {
 				resetBtn.Enable()
 			} else {
 				resetBtn.Disable()
@@ -296,27 +611,20 @@ func main() {
 			runBtn.SetText("Run")
 			stepBtn.Enable()
 		}
+		memList.Refresh()
 	}
 
-	go func() {
-		for {
-			if running {
-				continueRunning := nandpu.Step()
-				stepNum += 1
-				fyne.Do(updateGUIValues)
-				if !continueRunning {
-					running = false
-				}
-				speedVal, err := speed.Get()
-				if err != nil {
-					Logger.Fatalf("Error: %s", err)
-				}
-				time.Sleep(time.Millisecond * time.Duration(speedVal))
-			} else {
-				time.Sleep(time.Millisecond * 100)
+	ctrl = nandpu.NewController(sys, cfg.DefaultImage, newSystem, func(s *nandpu.RunState) {
+		fyne.Do(func() {
+			if state != nil && s.Generation != state.Generation {
+				closeDevices()
 			}
-		}
-	}()
+			state = s
+			updateGUIValues()
+		})
+	})
+	ctrl.SetClock(clockHz)
 
 	Wnd.ShowAndRun()
+	ctrl.Close()
 }